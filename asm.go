@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// asmKeyword is the command, on the go line of the UI, that finds the
+// assembly implementations of a Go function declared without a body.
+const asmKeyword = "asm"
+
+func asmDecls() map[string]string {
+	return map[string]string{
+		"text": `^TEXT[[:space:]]+[^(]*·{{word}}(<[^>]*>)?\(SB\)`,
+	}
+}
+
+// textSymbol matches a TEXT directive of a Go assembly file, and captures the
+// package part and the name part of the symbol.
+var textSymbol = regexp.MustCompile(`^TEXT[ \t]+([^·(]*)·([^(<]+)(<[^>]*>)?\(SB\)`)
+
+// knownArchs are the GOARCH values, as they can appear in file names.
+var knownArchs = map[string]bool{
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+	"mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+	"ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+}
+
+// asmArch returns the GOARCH, or the comma separated GOARCHes, that the
+// assembly file name is for, according to its name, or its build constraints.
+// It returns "" if they do not require any.
+func asmArch(name string) string {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(name), ".s"), "_")
+	if last := parts[len(parts)-1]; len(parts) > 1 && knownArchs[last] {
+		return last
+	}
+	expr := buildConstraint(name)
+	if expr == nil {
+		return ""
+	}
+	tags := make(map[string]bool)
+	constraintTags(expr, tags)
+	var others []string
+	for tag := range tags {
+		if !knownArchs[tag] {
+			others = append(others, tag)
+		}
+	}
+	if len(others) > 16 {
+		// not worth it.
+		return ""
+	}
+	var archs []string
+	for arch := range knownArchs {
+		if !satisfiable(expr, arch, others) {
+			continue
+		}
+		if !tags[arch] {
+			// e.g. !amd64, which is not for some arch in particular.
+			return ""
+		}
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return strings.Join(archs, ",")
+}
+
+// buildConstraint returns the build constraint of the file name, from its
+// //go:build line, or else from its +build lines, or nil if it has none.
+func buildConstraint(name string) constraint.Expr {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var expr constraint.Expr
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "//") {
+			if line == "" {
+				continue
+			}
+			break
+		}
+		x, err := constraint.Parse(line)
+		if err != nil {
+			continue
+		}
+		if constraint.IsGoBuild(line) {
+			return x
+		}
+		if expr == nil {
+			expr = x
+		} else {
+			expr = &constraint.AndExpr{X: expr, Y: x}
+		}
+	}
+	return expr
+}
+
+// constraintTags adds all the tags of x to tags.
+func constraintTags(x constraint.Expr, tags map[string]bool) {
+	switch x := x.(type) {
+	case *constraint.TagExpr:
+		tags[x.Tag] = true
+	case *constraint.NotExpr:
+		constraintTags(x.X, tags)
+	case *constraint.AndExpr:
+		constraintTags(x.X, tags)
+		constraintTags(x.Y, tags)
+	case *constraint.OrExpr:
+		constraintTags(x.X, tags)
+		constraintTags(x.Y, tags)
+	}
+}
+
+// satisfiable reports whether x holds for arch, for some values of the
+// other tags.
+func satisfiable(x constraint.Expr, arch string, others []string) bool {
+	for set := 0; set < 1<<len(others); set++ {
+		ok := x.Eval(func(tag string) bool {
+			if knownArchs[tag] {
+				return tag == arch
+			}
+			for i, v := range others {
+				if v == tag {
+					return set&(1<<i) != 0
+				}
+			}
+			return false
+		})
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// asmImpl is a TEXT symbol in an assembly file.
+type asmImpl struct {
+	file string
+	line int
+	arch string
+	text string
+}
+
+// asmImpls returns the TEXT symbols named name, in the assembly files of dirs,
+// or below locations if dirs is empty, sorted by arch.
+func asmImpls(name string, dirs []string, scopes []searchScope) ([]asmImpl, error) {
+	var impls []asmImpl
+	visit := func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for line := 1; sc.Scan(); line++ {
+			m := textSymbol.FindStringSubmatch(sc.Text())
+			if m == nil || m[2] != name {
+				continue
+			}
+			impls = append(impls, asmImpl{
+				file: path,
+				line: line,
+				arch: asmArch(path),
+				text: sc.Text(),
+			})
+		}
+		return nil
+	}
+	if len(dirs) == 0 {
+		if err := walkScopes(scopes, []string{`\.s`}, visit); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.s"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			visit(path)
+		}
+	}
+	sort.SliceStable(impls, func(i, j int) bool { return impls[i].arch < impls[j].arch })
+	return impls, nil
+}
+
+// bodylessFunc is a Go function declared without a body.
+type bodylessFunc struct {
+	pos  token.Position
+	decl string
+}
+
+// bodylessFuncs returns the declarations, below locations, of the Go
+// functions named name that have no body.
+func bodylessFuncs(name string, scopes []searchScope) ([]bodylessFunc, error) {
+	var funcs []bodylessFunc
+	err := walkScopes(scopes, []string{`\.go`}, func(path string) error {
+		src, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Contains(src, []byte(name)) {
+			// Not worth parsing.
+			return nil
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+		if err != nil {
+			return nil
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Body != nil || fd.Recv != nil || fd.Name.Name != name {
+				continue
+			}
+			pos := fset.Position(fd.Pos())
+			funcs = append(funcs, bodylessFunc{
+				pos:  pos,
+				decl: funcDeclLine(path, pos.Line),
+			})
+		}
+		return nil
+	})
+	return funcs, err
+}
+
+// funcDeclLine returns the text of line n of the file name.
+func funcDeclLine(name string, n int) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for i := 1; sc.Scan(); i++ {
+		if i == n {
+			return sc.Text()
+		}
+	}
+	return ""
+}
+
+// goToAsm sends to r the Go functions named what that have no body, each
+// followed by its assembly implementations in the same package, for each
+// GOARCH.
+func goToAsm(r *replier, what string, scopes []searchScope) error {
+	funcs, err := bodylessFuncs(what, scopes)
+	if err != nil {
+		return err
+	}
+	if len(funcs) == 0 {
+		return fmt.Errorf("no Go func %s without a body", what)
+	}
+	for _, fn := range funcs {
+		if err := r.result(fn.pos.Filename, fn.pos.Line, fn.decl); err != nil {
+			return err
+		}
+		impls, err := asmImpls(what, []string{filepath.Dir(fn.pos.Filename)}, nil)
+		if err != nil {
+			return err
+		}
+		if len(impls) == 0 {
+			if err := r.result(fn.pos.Filename, fn.pos.Line, " no assembly implementation"); err != nil {
+				return err
+			}
+		}
+		for _, impl := range impls {
+			arch := impl.arch
+			if arch == "" {
+				arch = "any"
+			}
+			if err := r.result(impl.file, impl.line, " ["+arch+"] "+impl.text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// asmToGo sends to r the Go prototypes of the TEXT symbol what, which can be
+// given as name, ·name, pkg·name, or ·name(SB).
+func asmToGo(r *replier, what string, scopes []searchScope) error {
+	name := what
+	if i := strings.LastIndex(name, "·"); i >= 0 {
+		name = name[i+len("·"):]
+	}
+	if i := strings.IndexAny(name, "<("); i >= 0 {
+		name = name[:i]
+	}
+	impls, err := asmImpls(name, nil, scopes)
+	if err != nil {
+		return err
+	}
+	// Only look in the packages that implement the symbol.
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, impl := range impls {
+		if dir := filepath.Dir(impl.file); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no TEXT symbol for %s", what)
+	}
+	found := false
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			funcs, err := bodylessFuncs(name, []searchScope{{locations: []string{path}}})
+			if err != nil {
+				return err
+			}
+			for _, fn := range funcs {
+				if err := r.result(fn.pos.Filename, fn.pos.Line, fn.decl); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no Go prototype for %s", what)
+	}
+	return nil
+}
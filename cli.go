@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+)
+
+// Exit codes of the CLI mode, like grep's.
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+// isCLI reports whether one of the CLI mode search flags was set.
+func isCLI() bool {
+	return *flagFunc != "" || *flagMethod != "" || *flagPkg != "" || *flagType != "" || *flagRegex != ""
+}
+
+// cliRequest returns the request for the search flag that was set.
+func cliRequest() (request, error) {
+	var reqs []request
+	goDecl := func(kind, what string) {
+		if what != "" {
+			reqs = append(reqs, request{Action: decl, Lang: "go", Decl: kind, What: escapeSpecials(what)})
+		}
+	}
+	goDecl("func", *flagFunc)
+	goDecl("meth", *flagMethod)
+	goDecl("pkg", *flagPkg)
+	goDecl("type", *flagType)
+	if *flagRegex != "" {
+		reqs = append(reqs, request{Action: regex, What: *flagRegex})
+	}
+	if len(reqs) != 1 {
+		return request{}, fmt.Errorf("-func, -method, -pkg, -type, and -regex are mutually exclusive")
+	}
+	req := reqs[0]
+	req.Version = protocolVersion
+	req.ID = newRequestID()
+	req.Project = *flagProject
+	req.Where = *flagWhere
+	return req, nil
+}
+
+// runCLI sends the search defined by the flags to the running server, or runs
+// it in-process with the configuration file in args (or the default one) if
+// there is no server, and prints the results on stdout. It returns the exit
+// code.
+func runCLI(args []string) int {
+	log.SetFlags(0)
+	log.SetPrefix("gofinder: ")
+	req, err := cliRequest()
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	var configErr error
+	if len(args) == 1 {
+		configFile = args[0]
+	} else {
+		configFile, configErr = defaultConfigFile()
+	}
+	conn, err := dialServer()
+	if err != nil {
+		if configErr != nil {
+			log.Printf("no server running (%v), and %v", err, configErr)
+			return exitError
+		}
+		if err := checkGNU(); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		if err := loadProjects(configFile); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		var server net.Conn
+		server, conn = net.Pipe()
+		go serve(server)
+	}
+	defer conn.Close()
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+
+	if req.Project == "" {
+		id := newRequestID()
+		if err := enc.Encode(request{Version: protocolVersion, ID: id, Action: doGetProjects}); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		if _, _, err := readResponses(dec, id); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		var names []string
+		for name := range projects {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			log.Print("no project in the configuration")
+			return exitError
+		}
+		sort.Strings(names)
+		req.Project = names[0]
+	}
+
+	if err := enc.Encode(req); err != nil {
+		log.Print(err)
+		return exitError
+	}
+	done, lastErr, err := readResponses(dec, req.ID)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	switch {
+	case done.Status != statusOK && (lastErr == nil || lastErr.Code != errNotFound):
+		return exitError
+	case done.Summary != nil && done.Summary.Matches > 0:
+		return exitMatch
+	}
+	return exitNoMatch
+}
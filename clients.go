@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"9fans.net/go/acme"
+)
+
+// configSet is what a configuration file defines: the projects, and the
+// languages they can use.
+type configSet struct {
+	projects  map[string]Project
+	languages map[string]Language
+	warnings  []string
+	// editable are the names of the projects of the configuration file
+	// itself, rather than of the files it includes, or of the user
+	// configuration file, which are the only ones the edit commands change.
+	editable map[string]bool
+}
+
+// uiClient is a UI registered with the server, with doRegister. Its requests
+// are served with the projects of its own configuration file, and their
+// history goes to its own window.
+type uiClient struct {
+	id     uint64
+	config string // absolute path of the configuration file
+	window int    // id of the acme window, 0 if none
+	cs     *configSet
+}
+
+var (
+	clientsMu    sync.Mutex
+	clients      = make(map[uint64]*uiClient)
+	lastClientID uint64
+
+	// clientID is the id we got from the server, when we are a UI.
+	clientID uint64
+)
+
+// register reads config for the client id, or for a new client if id is 0 or
+// unknown, and returns the id of the client.
+func register(id uint64, config string, window int) (uint64, error) {
+	if !filepath.IsAbs(config) {
+		return 0, fmt.Errorf("configuration file path %q is not absolute", config)
+	}
+	cs, err := readConfig(config)
+	if err != nil {
+		return 0, err
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if _, ok := clients[id]; !ok {
+		lastClientID++
+		id = lastClientID
+	}
+	clients[id] = &uiClient{id: id, config: config, window: window, cs: cs}
+	// So that the other clients for config, like the CLI, find us.
+	advertise(config)
+	return id, nil
+}
+
+func unregister(id uint64) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	forget(id)
+}
+
+// forget removes the client id, along with the discovery file for its
+// configuration file, if nothing else needs it. clientsMu must be held.
+func forget(id uint64) {
+	c, ok := clients[id]
+	if !ok {
+		return
+	}
+	delete(clients, id)
+	for _, other := range clients {
+		if other.config == c.config {
+			return
+		}
+	}
+	if abs, err := filepath.Abs(configFile); err == nil && abs == c.config {
+		return
+	}
+	unadvertise(c.config)
+}
+
+// lookupClient returns the client with id, or nil if there is none.
+func lookupClient(id uint64) *uiClient {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return clients[id]
+}
+
+// currentConfig holds our *configSet, which is replaced as a whole when the
+// configuration file is loaded again.
+var currentConfig atomic.Value
+
+// configOf returns the configuration to serve the requests of c with: its
+// own, or ours if c is nil.
+func configOf(c *uiClient) *configSet {
+	if c != nil {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return c.cs
+	}
+	if cs, ok := currentConfig.Load().(*configSet); ok {
+		return cs
+	}
+	return &configSet{}
+}
+
+// configFileOf returns the configuration file of c, or ours if c is nil.
+func configFileOf(c *uiClient) string {
+	if c != nil {
+		return c.config
+	}
+	if abs, err := filepath.Abs(configFile); err == nil {
+		return abs
+	}
+	return configFile
+}
+
+// reloadClients rereads the configuration files of all the clients.
+func reloadClients() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for _, c := range clients {
+		cs, err := readConfig(c.config)
+		if err != nil {
+			log.Printf("could not reload %v: %v", c.config, err)
+			continue
+		}
+		c.cs = cs
+	}
+}
+
+// pruneClients forgets about the clients whose window is gone, since they
+// can't unregister anymore, and returns how many are left.
+func pruneClients() int {
+	wins, err := acme.Windows()
+	if err != nil {
+		// no acme anymore, hence no windows.
+		wins = nil
+	}
+	alive := make(map[int]bool, len(wins))
+	for _, wi := range wins {
+		alive[wi.ID] = true
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for id, c := range clients {
+		if c.window != 0 && !alive[c.window] {
+			forget(id)
+		}
+	}
+	return len(clients)
+}
+
+// lingerForClients keeps on serving, after our own window is gone, until the
+// other windows that rely on our server are gone too.
+func lingerForClients() {
+	for n := pruneClients(); n > 0; n = pruneClients() {
+		log.Printf("still serving %d other window(s)", n)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// registerWindow registers our window and configuration file with the
+// server, or updates the registration if we already have one.
+func registerWindow() error {
+	config, err := filepath.Abs(configFile)
+	if err != nil {
+		return err
+	}
+	conn, err := dialServer()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	id := newRequestID()
+	if err := gob.NewEncoder(conn).Encode(request{
+		Version: protocolVersion,
+		ID:      id,
+		Action:  doRegister,
+		Client:  clientID,
+		Config:  config,
+		Window:  w.ID(),
+	}); err != nil {
+		return err
+	}
+	done, lastErr, err := readResponses(gob.NewDecoder(conn), id)
+	if err != nil {
+		return err
+	}
+	if done.Status != statusOK {
+		if lastErr != nil {
+			return lastErr
+		}
+		return fmt.Errorf("registration of window %d failed", w.ID())
+	}
+	return nil
+}
+
+// unregisterWindow tells the server our window is going away.
+func unregisterWindow() {
+	if clientID == 0 {
+		return
+	}
+	conn, err := dialServer()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	id := newRequestID()
+	if err := gob.NewEncoder(conn).Encode(request{Version: protocolVersion, ID: id, Action: doUnregister, Client: clientID}); err != nil {
+		return
+	}
+	readResponses(gob.NewDecoder(conn), id)
+}
+
+// addHistory records what in the History section of the window of c, if it
+// has one.
+func addHistory(c *uiClient, what string) {
+	if c == nil || c.window == 0 {
+		return
+	}
+	if w != nil && w.ID() == c.window {
+		w.Write("body", []byte(what+"\n"))
+		return
+	}
+	win, err := acme.Open(c.window, nil)
+	if err != nil {
+		log.Printf("could not open window %d: %v", c.window, err)
+		return
+	}
+	defer win.CloseFiles()
+	win.Write("body", []byte(what+"\n"))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configError is a problem with the value at the JSON path Path, in the
+// configuration file.
+type configError struct {
+	Path string
+	Msg  string
+}
+
+func (e configError) String() string {
+	return e.Path + ": " + e.Msg
+}
+
+// configErrors are all the errors found in a configuration file.
+type configErrors struct {
+	file string
+	errs []configError
+}
+
+func (e *configErrors) Error() string {
+	lines := []string{fmt.Sprintf("%d error(s) in %v:", len(e.errs), e.file)}
+	for _, ce := range e.errs {
+		lines = append(lines, "\t"+ce.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// overrideUserProjects removes, from the first n projects of conf, which come
+// from the user configuration file, the ones that have the same name as one
+// of the others.
+func overrideUserProjects(conf *config, n int) {
+	names := make(map[string]bool)
+	for _, p := range conf.Projects[n:] {
+		names[p.Name] = true
+	}
+	var projects []Project
+	var paths, files []string
+	for i, p := range conf.Projects {
+		if i < n && names[p.Name] {
+			continue
+		}
+		projects = append(projects, p)
+		paths = append(paths, conf.projectPaths[i])
+		files = append(files, conf.projectFiles[i])
+	}
+	conf.Projects, conf.projectPaths, conf.projectFiles = projects, paths, files
+}
+
+// decodeConfig decodes the configuration file data. legacy is whether it is
+// a JSON array of projects.
+func decodeConfig(data []byte) (conf config, legacy bool, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return conf, true, dec.Decode(&conf.Projects)
+	}
+	return conf, false, dec.Decode(&conf)
+}
+
+// unknownFields returns a warning, along with its JSON path, for each field
+// of the JSON value data[start:end] that is not one of t, or of the types
+// within t. Such fields are allowed, e.g. for notes of one's own, but they are
+// most likely typos.
+func unknownFields(data []byte, start, end int, t reflect.Type, path string) []configError {
+	value := bytes.TrimLeft(data[start:end], " \t\r\n")
+	if len(value) == 0 {
+		return nil
+	}
+	var warns []configError
+	switch t.Kind() {
+	case reflect.Struct:
+		if value[0] != '{' {
+			return nil
+		}
+		fields, err := jsonChildren(data, start, end)
+		if err != nil {
+			return nil
+		}
+		for _, f := range fields {
+			fpath := f.key
+			if path != "" {
+				fpath = path + "." + f.key
+			}
+			sf, ok := structField(t, f.key)
+			if !ok {
+				warns = append(warns, configError{Path: fpath, Msg: "unknown field, which is ignored"})
+				continue
+			}
+			warns = append(warns, unknownFields(data, f.start, f.end, sf.Type, fpath)...)
+		}
+	case reflect.Slice:
+		if value[0] != '[' {
+			return nil
+		}
+		elems, err := jsonChildren(data, start, end)
+		if err != nil {
+			return nil
+		}
+		for i, e := range elems {
+			warns = append(warns, unknownFields(data, e.start, e.end, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		if value[0] != '{' {
+			return nil
+		}
+		entries, err := jsonChildren(data, start, end)
+		if err != nil {
+			return nil
+		}
+		for _, e := range entries {
+			warns = append(warns, unknownFields(data, e.start, e.end, t.Elem(), path+"."+e.key)...)
+		}
+	}
+	return warns
+}
+
+// structField returns the field of the struct type t that the JSON key
+// decodes into, matched as encoding/json does.
+func structField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// readConfig reads and validates the configuration file, along with the ones
+// it includes, and the user configuration file, whose projects are replaced
+// by the ones of file with the same name. The configuration is rejected if
+// there are errors, while the warnings are only recorded in the returned
+// configSet.
+func readConfig(file string) (*configSet, error) {
+	ld := &configLoader{loaded: make(map[string]bool)}
+	if user := userConfigFor(file); user != "" {
+		if err := ld.load(user, user+": ", nil); err != nil {
+			return nil, err
+		}
+	}
+	userProjects := len(ld.conf.Projects)
+	if err := ld.load(file, "", nil); err != nil {
+		return nil, err
+	}
+	conf := &ld.conf
+	overrideUserProjects(conf, userProjects)
+	cs := &configSet{
+		projects:  make(map[string]Project, len(conf.Projects)),
+		languages: mergeLanguages(conf.Languages),
+		editable:  make(map[string]bool),
+	}
+	errs := append(ld.errs, inheritProjects(conf)...)
+	verrs, warns := validateConfig(conf, cs.languages)
+	errs = append(errs, verrs...)
+	if len(errs) > 0 {
+		return nil, &configErrors{file: file, errs: errs}
+	}
+	for _, w := range append(ld.warns, warns...) {
+		cs.warnings = append(cs.warnings, w.String())
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range conf.Projects {
+		cs.projects[v.Name] = v
+		if conf.projectFiles[i] == abs {
+			cs.editable[v.Name] = true
+		}
+	}
+	return cs, nil
+}
+
+// jsonError adds the line and column where it happened to the JSON decoding
+// error err, when known.
+func jsonError(data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %v", line, col, err)
+}
+
+// validateConfig checks conf, and returns the errors and warnings found,
+// along with their JSON path. langs are all the languages of conf.
+func validateConfig(conf *config, langs map[string]Language) (errs, warns []configError) {
+	addErr := func(path, format string, args ...interface{}) {
+		errs = append(errs, configError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	for i, l := range conf.Languages {
+		path := conf.languagePaths[i]
+		if l.Name == "" {
+			addErr(path+".Name", "missing")
+		}
+		errs = append(errs, checkPatterns(path+".Exts", l.Exts)...)
+		var kinds []string
+		for kind := range l.Decls {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			pattern := strings.Replace(l.Decls[kind], wordPlaceholder, "x", -1)
+			if _, err := regexp.Compile(pattern); err != nil {
+				addErr(path+".Decls."+kind, "%v", err)
+			}
+		}
+	}
+
+	names := make(map[string]string)
+	for i, p := range conf.Projects {
+		path := conf.projectPaths[i]
+		switch {
+		case p.Name == "":
+			addErr(path+".Name", "missing")
+		case projectWord.FindString(p.Name+":") != p.Name+":":
+			addErr(path+".Name", "%q is not one word of letters only, which is needed to chord on it", p.Name)
+		case names[p.Name] != "":
+			addErr(path+".Name", "%q is already the name of %s", p.Name, names[p.Name])
+		}
+		if _, ok := names[p.Name]; !ok {
+			names[p.Name] = path
+		}
+		if len(p.Locations) == 0 {
+			addErr(path+".Locations", "missing")
+		}
+		labels := make(map[string]int)
+		for j, l := range p.Locations {
+			lpath := fmt.Sprintf("%s.Locations[%d]", path, j)
+			if l.Path == "" {
+				addErr(lpath+".Path", "missing")
+				continue
+			}
+			if l.Label != "" {
+				if strings.ContainsAny(l.Label, " \t\n") {
+					addErr(lpath+".Label", "%q is not one word, which is needed to chord on it", l.Label)
+				} else if k, ok := labels[l.Label]; ok {
+					addErr(lpath+".Label", "%q is already the label of Locations[%d]", l.Label, k)
+				} else {
+					labels[l.Label] = j
+				}
+			}
+			errs = append(errs, checkPatterns(lpath+".Exts", l.Exts)...)
+			errs = append(errs, checkPatterns(lpath+".Excluded", l.Excluded)...)
+			loc := l.Path
+			fi, err := os.Stat(loc)
+			if err != nil {
+				if l.Optional {
+					warns = append(warns, configError{Path: lpath, Msg: fmt.Sprintf("%v, so it is not searched", err)})
+				} else {
+					addErr(lpath, "%v", err)
+				}
+				continue
+			}
+			if !fi.IsDir() {
+				addErr(lpath, "%v is not a directory", loc)
+			}
+			for k, o := range p.Locations {
+				other := o.Path
+				if k == j || other == "" || !isBelow(loc, other) {
+					continue
+				}
+				if isBelow(other, loc) {
+					// the same one. only warn about the last.
+					if k < j {
+						warns = append(warns, configError{Path: lpath, Msg: fmt.Sprintf("%v is also Locations[%d], so its files are searched twice", loc, k)})
+					}
+					continue
+				}
+				warns = append(warns, configError{
+					Path: lpath,
+					Msg:  fmt.Sprintf("%v is within %v (Locations[%d]), so its files are searched twice", loc, other, k),
+				})
+			}
+		}
+		errs = append(errs, checkPatterns(path+".Exts", p.Exts)...)
+		errs = append(errs, checkPatterns(path+".Excluded", p.Excluded)...)
+		for j, name := range p.Languages {
+			if _, ok := langs[name]; !ok {
+				addErr(fmt.Sprintf("%s.Languages[%d]", path, j), "unknown language %q", name)
+			}
+		}
+	}
+	return errs, warns
+}
+
+// checkPatterns returns an error for each of patterns, at path, that is not
+// a valid regexp, or glob.
+func checkPatterns(path string, patterns []string) []configError {
+	var errs []configError
+	for i, v := range patterns {
+		if _, err := compilePattern(v); err != nil {
+			errs = append(errs, configError{Path: fmt.Sprintf("%s[%d]", path, i), Msg: err.Error()})
+		}
+	}
+	return errs
+}
+
+// isBelow reports whether the directory dir is parent, or below it.
+func isBelow(dir, parent string) bool {
+	dir, parent = filepath.Clean(dir), filepath.Clean(parent)
+	return dir == parent || strings.HasPrefix(dir, parent+string(filepath.Separator))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runDaemon serves the searches for the projects of configFile, without any
+// acme window. The configuration is reloaded when it changes, and on SIGHUP.
+func runDaemon() error {
+	if err := checkGNU(); err != nil {
+		return err
+	}
+	if err := loadProjects(configFile); err != nil {
+		return err
+	}
+	logWarnings()
+	ln, err := newListener()
+	if err != nil {
+		return err
+	}
+	setListener(ln)
+	defer unadvertiseAll()
+	c := make(chan int)
+	go listen(ln, c)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	changed := make(chan bool, 1)
+	go watchConfigs(changed)
+	for {
+		select {
+		case <-c:
+			return errors.New("server stopped")
+		case sig := <-quit:
+			return fmt.Errorf("got %v", sig)
+		case <-hup:
+			reloadClients()
+		case <-changed:
+		}
+		if err := loadProjects(configFile); err != nil {
+			log.Printf("could not reload %v, still using the previous configuration: %v", configFile, err)
+			continue
+		}
+		log.Printf("reloaded %v", configFile)
+		logWarnings()
+	}
+}
+
+func logWarnings() {
+	for _, v := range configWarnings {
+		log.Printf("warning: %v", v)
+	}
+}
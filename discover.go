@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"9fans.net/go/acme"
+)
+
+// discovered is a project found by -discover: a Go module, or a repository
+// without any.
+type discovered struct {
+	dir    string // absolute
+	module string // module path, "" for a repository
+}
+
+// discoverSkipped reports whether the directory name is never searched for
+// projects, nor for their files.
+func discoverSkipped(name string) bool {
+	switch name {
+	case "vendor", "node_modules", "testdata":
+		return true
+	}
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// discover proposes a configuration with a project for each Go module, for
+// each module used by a go.work workspace, and for each repository without
+// any module, found below root. It is previewed in an acme window, where Put
+// writes it to gofind.json in root, or printed on stdout without acme.
+func discover(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(root, repoConfigName)
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("refusing to overwrite existing config file at %v", file)
+	}
+	found, err := discoverProjects(root)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no Go module, go.work workspace, or repository found below %v", root)
+	}
+	conf := config{Projects: proposeProjects(root, found)}
+	data, err := marshalIndent(conf, "", "	")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	win, err := acme.New()
+	if err != nil {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	defer win.CloseFiles()
+	win.Name(file)
+	win.Write("body", data)
+	win.Addr("0")
+	win.Ctl("dot=addr")
+	win.Ctl("show")
+	return nil
+}
+
+// discoverProjects walks root for the Go modules, the go.work workspaces, and
+// the repositories.
+func discoverProjects(root string) ([]discovered, error) {
+	byDir := make(map[string]*discovered)
+	var repos []string
+	add := func(dir string) error {
+		if _, ok := byDir[dir]; ok {
+			return nil
+		}
+		module, err := modulePath(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return err
+		}
+		byDir[dir] = &discovered{dir: dir, module: module}
+		return nil
+	}
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// like find, skip what can't be read.
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if p != root && discoverSkipped(fi.Name()) {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+			if err := add(p); err != nil {
+				return err
+			}
+		}
+		if uses, err := workspaceModules(filepath.Join(p, "go.work")); err == nil {
+			for _, dir := range uses {
+				if err := add(dir); err != nil {
+					return err
+				}
+			}
+		}
+		for _, vcs := range []string{".git", ".hg", ".svn", ".bzr"} {
+			if _, err := os.Stat(filepath.Join(p, vcs)); err == nil {
+				repos = append(repos, p)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A repository is only a project of its own when none of the modules is
+	// in it, or contains it.
+	for _, repo := range repos {
+		covered := false
+		for dir := range byDir {
+			if isBelow(dir, repo) || isBelow(repo, dir) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			byDir[repo] = &discovered{dir: repo}
+		}
+	}
+	var found []discovered
+	for _, d := range byDir {
+		found = append(found, *d)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].dir < found[j].dir })
+	return found, nil
+}
+
+var (
+	moduleLine   = regexp.MustCompile(`^module[ \t]+"?([^" \t]+)"?`)
+	useLine      = regexp.MustCompile(`^use[ \t]+([^( \t]+)`)
+	majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+)
+
+// modulePath returns the module path declared in the go.mod file, which must
+// exist.
+func modulePath(gomod string) (string, error) {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if m := moduleLine.FindStringSubmatch(strings.TrimSpace(sc.Text())); m != nil {
+			return m[1], nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no module directive in %v", gomod)
+}
+
+// workspaceModules returns the directories of the modules that the go.work
+// file uses, if they have a go.mod.
+func workspaceModules(gowork string) ([]string, error) {
+	f, err := os.Open(gowork)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var uses []string
+	inBlock := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case inBlock && line == ")":
+			inBlock = false
+		case inBlock && line != "":
+			uses = append(uses, strings.Trim(line, `"`))
+		case line == "use (":
+			inBlock = true
+		default:
+			if m := useLine.FindStringSubmatch(line); m != nil {
+				uses = append(uses, strings.Trim(m[1], `"`))
+			}
+		}
+	}
+	var dirs []string
+	for _, use := range uses {
+		dir := use
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(gowork), dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, sc.Err()
+}
+
+// proposeProjects returns a project for each of found, whose paths are
+// relative to root.
+func proposeProjects(root string, found []discovered) []Project {
+	names := make(map[string]bool)
+	var projects []Project
+	for _, d := range found {
+		var nested []string
+		for _, other := range found {
+			if other.dir != d.dir && isBelow(other.dir, d.dir) {
+				nested = append(nested, other.dir)
+			}
+		}
+		exts, mains := surveyFiles(d.dir, nested)
+		p := Project{
+			Name:      projectName(d, names),
+			Locations: []Location{{Path: relativePath(root, d.dir)}},
+			Exts:      exts,
+		}
+		for _, dir := range nested {
+			// the files of a nested module belong to its own project.
+			rel, _ := filepath.Rel(d.dir, dir)
+			p.Excluded = append(p.Excluded, globPrefix+"/"+filepath.ToSlash(rel))
+		}
+		if d.module != "" {
+			for _, dir := range mains {
+				p.GuruScope = append(p.GuruScope, importPath(d, dir))
+			}
+			if len(p.GuruScope) == 0 {
+				p.GuruScope = []string{d.module + "/..."}
+			}
+		}
+		projects = append(projects, p)
+	}
+	return projects
+}
+
+// surveyFiles walks dir, but for the nested directories, and returns the Exts
+// of the builtin languages of its files, and the directories of its main
+// packages.
+func surveyFiles(dir string, nested []string) (exts, mains []string) {
+	langExts := make(map[string]*regexp.Regexp, len(builtinLanguages))
+	for _, l := range builtinLanguages {
+		langExts[l.Name] = regexp.MustCompile(`(` + strings.Join(l.Exts, "|") + `)$`)
+	}
+	present := make(map[string]bool)
+	isMain := make(map[string]bool)
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi.IsDir() {
+			if p != dir && discoverSkipped(fi.Name()) {
+				return filepath.SkipDir
+			}
+			for _, n := range nested {
+				if p == n {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		for name, rx := range langExts {
+			if !present[name] && rx.MatchString(p) {
+				present[name] = true
+			}
+		}
+		if strings.HasSuffix(p, ".go") && !strings.HasSuffix(p, "_test.go") && !isMain[filepath.Dir(p)] {
+			f, err := parser.ParseFile(token.NewFileSet(), p, nil, parser.PackageClauseOnly)
+			if err == nil && f.Name.Name == "main" {
+				isMain[filepath.Dir(p)] = true
+			}
+		}
+		return nil
+	})
+	for _, l := range builtinLanguages {
+		if present[l.Name] {
+			exts = append(exts, l.Exts...)
+		}
+	}
+	if len(exts) == 0 {
+		exts = []string{`\.go`}
+	}
+	for d := range isMain {
+		mains = append(mains, d)
+	}
+	sort.Strings(mains)
+	return exts, mains
+}
+
+// importPath returns the import path of the package in dir, in the module d.
+func importPath(d discovered, dir string) string {
+	rel, err := filepath.Rel(d.dir, dir)
+	if err != nil || rel == "." {
+		return d.module
+	}
+	return path.Join(d.module, filepath.ToSlash(rel))
+}
+
+// projectName returns a name for d that is not in names yet, made of the
+// letters of the last elements of its module path, or of its directory.
+func projectName(d discovered, names map[string]bool) string {
+	elems := strings.Split(filepath.ToSlash(d.dir), "/")
+	if d.module != "" {
+		elems = strings.Split(d.module, "/")
+		if last := elems[len(elems)-1]; len(elems) > 1 && majorVersion.MatchString(last) {
+			// major version suffix.
+			elems = elems[:len(elems)-1]
+		}
+	}
+	letters := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	name := ""
+	for i := len(elems) - 1; i >= 0; i-- {
+		name = letters(elems[i]) + name
+		if name != "" && !names[name] {
+			names[name] = true
+			return name
+		}
+	}
+	if name == "" {
+		name = "project"
+	}
+	for names[name] {
+		name += "x"
+	}
+	names[name] = true
+	return name
+}
+
+// relativePath returns dir relative to root, if it can be.
+func relativePath(root, dir string) string {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return dir
+	}
+	return rel
+}
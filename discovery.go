@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// discoveryDirName is the name of the directory, in socketDir, where the
+// servers write a discovery file for each configuration file they serve.
+const discoveryDirName = "gofinder.d"
+
+// serverEntry is the content of a discovery file: how to reach the server
+// for a configuration file.
+type serverEntry struct {
+	Config  string
+	Network string
+	Addr    string
+	PID     int
+}
+
+var (
+	advertisedMu sync.Mutex
+	// listenAddr is the address of our server, if we run one.
+	listenAddr net.Addr
+	// advertised are the configuration files for which we wrote a
+	// discovery file.
+	advertised = make(map[string]bool)
+)
+
+// discoveryPath returns the path of the discovery file for the configuration
+// file config.
+func discoveryPath(config string) (string, error) {
+	dir, err := socketDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, discoveryDirName, fmt.Sprintf("%x.json", sum[:8])), nil
+}
+
+// setListener records that our server listens on ln, and advertises it for
+// our configuration file. It also cleans up the discovery files that dead
+// servers left behind.
+func setListener(ln net.Listener) {
+	advertisedMu.Lock()
+	listenAddr = ln.Addr()
+	advertisedMu.Unlock()
+	cleanDiscovery()
+	if configFile != "" {
+		advertise(configFile)
+	}
+	log.Printf("listening on %v", ln.Addr())
+}
+
+// advertise writes the discovery file for config, that points to our server.
+func advertise(config string) {
+	advertisedMu.Lock()
+	defer advertisedMu.Unlock()
+	if listenAddr == nil {
+		return
+	}
+	abs, err := filepath.Abs(config)
+	if err == nil {
+		err = writeEntry(abs, listenAddr)
+	}
+	if err != nil {
+		log.Printf("could not write discovery file for %v: %v", config, err)
+		return
+	}
+	advertised[abs] = true
+}
+
+// writeEntry atomically writes the discovery file for the configuration
+// file config, an absolute path, that points to addr.
+func writeEntry(config string, addr net.Addr) error {
+	name, err := discoveryPath(config)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(serverEntry{
+		Config:  config,
+		Network: addr.Network(),
+		Addr:    addr.String(),
+		PID:     os.Getpid(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to name, which is then
+// renamed to name, so that readers never see a partial file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// unadvertise removes the discovery file for config, an absolute path, if we
+// wrote it.
+func unadvertise(config string) {
+	advertisedMu.Lock()
+	defer advertisedMu.Unlock()
+	if !advertised[config] {
+		return
+	}
+	delete(advertised, config)
+	removeOwnEntry(config)
+}
+
+// unadvertiseAll removes all the discovery files we wrote, when our server
+// stops.
+func unadvertiseAll() {
+	advertisedMu.Lock()
+	defer advertisedMu.Unlock()
+	for config := range advertised {
+		removeOwnEntry(config)
+	}
+	advertised = make(map[string]bool)
+}
+
+// removeOwnEntry removes the discovery file for config, unless another
+// server has replaced it since.
+func removeOwnEntry(config string) {
+	name, err := discoveryPath(config)
+	if err != nil {
+		return
+	}
+	if e, err := readEntry(name); err == nil && e.PID != os.Getpid() {
+		return
+	}
+	os.Remove(name)
+}
+
+func readEntry(name string) (*serverEntry, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var e serverEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// processAlive reports whether the process pid is still running.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// lookupServer returns the discovery entry of the server for config, if
+// there is one, and if its process is still running. A stale entry is
+// removed.
+func lookupServer(config string) (*serverEntry, bool) {
+	if config == "" {
+		return nil, false
+	}
+	name, err := discoveryPath(config)
+	if err != nil {
+		return nil, false
+	}
+	e, err := readEntry(name)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			// corrupted. nothing to salvage.
+			os.Remove(name)
+		}
+		return nil, false
+	}
+	if !processAlive(e.PID) {
+		os.Remove(name)
+		return nil, false
+	}
+	return e, true
+}
+
+// dialDiscovered connects to the server for config, as found through its
+// discovery file.
+func dialDiscovered(config string) (net.Conn, error) {
+	e, ok := lookupServer(config)
+	if !ok {
+		return nil, fmt.Errorf("no server found for %v", config)
+	}
+	conn, err := net.Dial(e.Network, e.Addr)
+	if err != nil {
+		// The process is there, but it is not our server anymore, or it
+		// does not listen anymore.
+		if name, err := discoveryPath(config); err == nil {
+			os.Remove(name)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// cleanDiscovery removes the discovery files whose server is gone.
+func cleanDiscovery() {
+	dir, err := socketDir()
+	if err != nil {
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(dir, discoveryDirName, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		e, err := readEntry(name)
+		if err != nil || !processAlive(e.PID) {
+			os.Remove(name)
+		}
+	}
+}
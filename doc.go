@@ -0,0 +1,220 @@
+// Copyright 2011 Mathieu Lonjaret
+
+// Code generated by gendoc.go from docTxt in main.go. DO NOT EDIT.
+
+// The gofinder program is an acme user interface to search through Go projects.
+// It uses 2-1 chording (see https://swtch.com/plan9port/man/man1/acme.html).
+// It uses a JSON configuration file to define project(s) to search on; see
+// projects-example.json for a working configuration example.
+// It also relies on GNU (not bsd!) grep and find.
+//
+// It displays, in the following order: The name of the project, to perform a
+// global search. The Go Guru (golang.org/x/tools/cmd/guru) modes, to perform a
+// guru search. The project's locations, to perform a local search. For example,
+// with the provided projects-example.json, the UI will look like:
+//
+//	Search in:
+//	-----------------------------------
+//	camlistore:
+//		callees	callers	callstack	definition	describe	freevars	implements	peers	pointsto	referrers	what	whicherrs
+//		go:	func	meth	pkg	type	asm
+//		/home/mpl/src/camlistore.org	/home/mpl/src/camlistore.org/vendor	/home/mpl/src/go4.org	/home/mpl/src/github.com/mpl
+//	-----------------------------------
+//
+// A brief recap on acme mouse chording: first place the text cursor on the word
+// you want the search to apply to, with a left click at any position on the word.
+// Then send that word as an argument to one of the guru commands with 2-1
+// chording. That means, press and hold the middle click on the command (for example, the
+// "definition" word), and while still holding it, press the left click.
+//
+// The output of commands is printed to the +Errors window.
+//
+// Each search ends with its metrics: the number of matches, of files walked, of
+// files filtered out by Exts or Excluded, of bytes read, and how long walking and
+// matching took. The Stats command, in the tag of the window, prints the totals
+// for each project (of each configuration file) since the server started, along
+// with its slowest search.
+//
+// With -daemon, gofinder runs without an acme window, and only serves the
+// searches of other clients, over a Unix domain socket (or over localhost TCP,
+// with -p). The configuration file is then also reloaded on SIGHUP.
+//
+// Several gofinder windows, even with different configuration files, share one
+// server: the first gofinder started serves the searches of the ones started
+// after it, which register their configuration file and window with it, so that
+// each window gets its own projects and history. That server keeps running after
+// its own window is deleted, for as long as the other windows are there.
+//
+// A server writes a discovery file, with its address and PID, for each
+// configuration file it serves, in the gofinder.d directory next to the socket.
+// The clients look there first for the server of their configuration file, which
+// is how they find a server that listens on any free port, with -p 0. The
+// discovery files of the servers that are gone are removed.
+//
+// With -http port, gofinder also serves a web UI on localhost:port, for the ones
+// who do not use acme. It lists the projects and their locations, and offers the
+// same searches as the acme UI. The results are shown as they come, and link to a
+// view of the source file, with the matching line highlighted. Only the files in
+// the locations of the projects can be viewed.
+//
+// With one of the -func, -method, -pkg, -type, or -regex flags, gofinder is a
+// command-line client instead: it sends the search to the running server (or
+// runs it by itself, with the given configuration file, if there is none), and
+// prints the results on stdout, in the same format as grep -n. As with grep, the
+// exit code is 0 if there were matches, 1 if there were none, and 2 on error.
+//
+// The configuration file is mapped to a project type, which is defined as follows:
+//
+//	type Project struct {
+//		// Name is the one word name describing the project, that will appear at
+//		// the top of the UI. One word, because chording on the name starts a
+//		// global search in the project. Global search means a find on all the
+//		// files ending with the extensions defined in Exts, looking in the
+//		// locations defined in Locations, excluding all the patterns defined in
+//		// Excluded. The results are piped to a grep for the argument that is sent
+//		// with the chord.
+//		Name      string
+//		// Extends is the name of a project that this one inherits the Locations,
+//		// Exts, Excluded, and GuruScope from, when it does not set them. An
+//		// element "..." in one of them stands for the ones of that project.
+//		Extends   string
+//		// Locations defines all the locations relevant to the project, and as
+//		// such, they are displayed on the UI. A global search runs find through
+//		// all of them. A chording on one of the locations will perform a local
+//		// search, i.e. in the same way as a global search, except find will only
+//		// run through that one location.
+//		Locations []Location
+//		// Exts defines the file extension patterns (regexp or glob), that find will
+//		// take into account. It defaults to []string{"\.go"} otherwise.
+//		Exts      []string
+//		// Excluded defines the patterns (regexp or glob), that find will take into
+//		// account to exclude from the search results.
+//		Excluded  []string
+//		// GuruScope is the scope that guru will use for the modes that need one.
+//		GuruScope []string
+//		// Languages lists the names of the languages for which declaration
+//		// searches are offered. It defaults to all the languages that have an
+//		// extension in common with Exts.
+//		Languages []string
+//		// Tags maps a Location to a ctags (tags) or etags (TAGS) file, used to
+//		// find declarations in that Location. A relative path is relative to
+//		// the Location.
+//		Tags map[string]string
+//	}
+//
+// Each project also has a line per language, listing the kinds of declarations
+// (func, type, etc) that can be searched for in that language. The builtin
+// languages are go, python, cpp, fortran, js, ts, proto, and asm. Other languages
+// can be defined, or the builtin ones overridden, in the configuration file. It
+// is then an object with a Languages and a Projects field, instead of an array of
+// projects:
+//
+//	{"Languages": [{"Name": "rust", "Exts": ["\\.rs"],
+//		"Decls": {"fn": "^[[:space:]]*(pub[[:space:]]+)?fn[[:space:]]+{{word}}[[:space:]<(]"}}],
+//	"Projects": [...]}
+//
+// where each occurrence of {{word}} in a declaration pattern is replaced with the
+// searched word.
+//
+// Languages with import paths, like js and ts, also have an open command on their
+// line, to open the file that the chorded import path refers to.
+//
+// The proto line has a go command, that finds the Go code generated for a message,
+// enum, service, or rpc, according to the go_package option and to the
+// protoc-gen-go naming rules. Conversely, when the project has the proto language,
+// the go line has a proto command, that finds the proto definition of a generated
+// Go type or method.
+//
+// Similarly, the asm command on the go line finds the Go functions declared
+// without a body, along with their assembly (TEXT symbol) implementations for each
+// GOARCH. When the project has the asm language, the go command on the asm line
+// finds the Go prototype of a TEXT symbol.
+//
+// A project with Tags has a tags line, which lists the all command, followed by
+// all the kinds of tags found in its tags files. Chording on one of them looks up
+// the tags of that kind (or of any kind, for all) named after the argument. The
+// tags files are loaded again for the lookups whenever they change, but the tags
+// line only lists the kinds found when the window was last drawn, e.g. on Reload.
+//
+// A Location is either just a path, or an object with a Path, and optionally its
+// own Exts and Excluded, which replace the ones of the project for the searches
+// in that Location, and a one word Label, shown on the UI instead of the path:
+//
+//	"Locations": ["/home/me/src/foo",
+//		{"Path": "/home/me/src/bar/web", "Exts": ["\\.js", "\\.css"], "Label": "web"}]
+//
+// A Location with "Optional": true, e.g. on a mount that is not always there, may
+// not exist: it is then only a warning, and it is not searched until it is back.
+//
+// The Exts and Excluded patterns can also be globs, prefixed with "glob:", in the
+// gitignore style: they are matched against the path relative to the Location, a
+// glob without a slash matches a name at any depth, ** matches any number of
+// directories, and a glob that matches a directory also matches all its files.
+// E.g. "glob:*.pb.go", or "glob:**/third_party/closure/**".
+//
+// In the Locations, the GuruScope, and the Tags, the environment variables ($VAR
+// or ${VAR}) are expanded, and so is a leading ~ in the paths. $GOPATH (its first
+// directory) and $GOMODCACHE default to what the go command uses, and any other
+// unset variable is an error. A relative Location is relative to the directory of
+// the configuration file, so that the same file can be shared, e.g. by checking
+// it in, along with the code.
+//
+// The configuration file can include other ones, whose languages and projects are
+// added to its own, with an Include field, and a project can extend another one,
+// e.g. to share the Excluded patterns:
+//
+//	{"Include": ["shared/go4.json"],
+//	"Projects": [{"Name": "perkeep", "Extends": "go4",
+//		"Locations": ["...", "$GOPATH/src/perkeep.org"],
+//		"Excluded": ["...", "glob:**/third_party/closure/**"]}]}
+//
+// where perkeep searches the Locations of go4, and its own, and it also uses the
+// Exts of go4. Include cycles, Extends cycles, and unknown projects in Extends
+// are errors. The included files are not watched: they are read again along with
+// the configuration file, e.g. on Reload.
+//
+// Without a configuration file argument, gofinder uses the gofind.json (as
+// written by -there) of the current directory, or of the nearest of its parents.
+// The user configuration file, $XDG_CONFIG_HOME/gofinder/config.json (or
+// ~/.config/gofinder/config.json), if it exists, is loaded along with any
+// configuration file, and is used alone if there is no gofind.json. It is for
+// the projects that should always be there, like the standard library, and for
+// the projects that others extend. A project of the configuration file replaces
+// the project of the user configuration file with the same name.
+//
+// With -discover root, gofinder proposes a gofind.json for root, with a project
+// for each Go module, each module used by a go.work workspace, and each
+// repository without any module, found below root. The Exts are the ones of the
+// builtin languages whose files are there, the GuruScope lists the main packages,
+// and the modules nested in a module are excluded from its project. The proposal
+// is shown in an acme window, for review, where Put writes it to the gofind.json
+// of root. Without acme, it is printed on stdout.
+//
+// With -there location, gofinder adds a project for location to the gofind.json of
+// location, and creates it if there is none. The gofind.json files of the parents
+// of location are left alone. When a project already has that name or location,
+// the location is only added to that project, if needed. Everything else in the
+// file is kept, in the same order, and the previous version of the file is saved
+// as gofind.json.bak.
+//
+// The edit line of a project window changes the project in the configuration file.
+// Chord AddLoc with a directory to add it to the Locations, Exclude with a regexp
+// or a glob to add it to the Excluded, and DelLoc with a location, or its label,
+// to remove it from the Locations. The rest of the file, and its formatting, is
+// kept, and the window is redrawn once the configuration is loaded again. Only the
+// projects of the configuration file itself can be edited, and have an edit line,
+// not the ones of the files it includes, or of the user configuration file.
+//
+// The configuration file is checked when it is loaded, and on Reload: the
+// patterns must be valid regexps, the Locations must be existing directories, and
+// the Names must be unique, and one word of letters only. The errors, along with
+// their JSON path (e.g. Projects[1].Exts[0]), are shown at the top of the window,
+// as are the warnings, e.g. about a Location within another one, an Optional one
+// that does not exist, or an unknown field, which is most likely a typo (fields
+// of one's own, e.g. an Owner, are otherwise ignored).
+//
+// The configuration file is watched (with inotify on Linux, and by polling it
+// otherwise), and loaded again as soon as it changes, after which the window is
+// redrawn. If the new configuration has errors, the previous one stays in use,
+// and the errors are shown at the top of the window until they are fixed.
+package main
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// editKeyword starts the line of the edit commands of a project, which change
+// the project in the configuration file.
+const editKeyword = "edit"
+
+const (
+	// addLocCmd adds its argument, a directory, to the Locations.
+	addLocCmd = "AddLoc"
+	// excludeCmd adds its argument, a regexp or a glob, to the Excluded.
+	excludeCmd = "Exclude"
+	// delLocCmd removes its argument, a location as shown on the UI, from
+	// the Locations.
+	delLocCmd = "DelLoc"
+)
+
+var editCommands = []string{addLocCmd, excludeCmd, delLocCmd}
+
+func isEditCommand(cmd string) bool {
+	for _, v := range editCommands {
+		if v == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonSpan is where a JSON value is in a file, along with its key when it is
+// the field of an object.
+type jsonSpan struct {
+	key        string
+	keyStart   int
+	start, end int
+}
+
+// jsonChildren returns the spans of the fields of the JSON object, or of the
+// elements of the JSON array, that is data[start:end].
+func jsonChildren(data []byte, start, end int) ([]jsonSpan, error) {
+	dec := json.NewDecoder(bytes.NewReader(data[start:end]))
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, _ := t.(json.Delim)
+	if delim != '{' && delim != '[' {
+		return nil, errors.New("not a JSON object or array")
+	}
+	var spans []jsonSpan
+	for dec.More() {
+		var s jsonSpan
+		if delim == '{' {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			s.key, _ = t.(string)
+			keyEnd := start + int(dec.InputOffset())
+			s.keyStart = bytes.LastIndexByte(data[:keyEnd-1], '"')
+			for s.keyStart > 0 && data[s.keyStart-1] == '\\' {
+				s.keyStart = bytes.LastIndexByte(data[:s.keyStart-1], '"')
+			}
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		s.end = start + int(dec.InputOffset())
+		s.start = s.end - len(raw)
+		if delim == '[' {
+			s.keyStart = s.start
+		}
+		spans = append(spans, s)
+	}
+	return spans, nil
+}
+
+// field returns the span of the field key, matched as encoding/json does.
+func field(fields []jsonSpan, key string) (jsonSpan, bool) {
+	for _, f := range fields {
+		if strings.EqualFold(f.key, key) {
+			return f, true
+		}
+	}
+	return jsonSpan{}, false
+}
+
+// projectFields returns the span of the project named name in the
+// configuration file data, and the spans of its fields.
+func projectFields(data []byte, name string) (jsonSpan, []jsonSpan, error) {
+	list := jsonSpan{start: 0, end: len(data)}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '[' {
+		top, err := jsonChildren(data, 0, len(data))
+		if err != nil {
+			return jsonSpan{}, nil, err
+		}
+		var ok bool
+		if list, ok = field(top, "Projects"); !ok {
+			return jsonSpan{}, nil, errors.New("no Projects")
+		}
+	}
+	projects, err := jsonChildren(data, list.start, list.end)
+	if err != nil {
+		return jsonSpan{}, nil, err
+	}
+	for _, p := range projects {
+		fields, err := jsonChildren(data, p.start, p.end)
+		if err != nil {
+			return jsonSpan{}, nil, err
+		}
+		f, ok := field(fields, "Name")
+		if !ok {
+			continue
+		}
+		var v string
+		if json.Unmarshal(data[f.start:f.end], &v) == nil && v == name {
+			return p, fields, nil
+		}
+	}
+	return jsonSpan{}, nil, fmt.Errorf("no project named %v", name)
+}
+
+// lineIndent returns the indentation of the line of data at offset.
+func lineIndent(data []byte, offset int) string {
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	end := start
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
+
+// indentUnit returns what the lines of children add to the indentation of
+// their parent, or def if they are not on lines of their own.
+func indentUnit(data []byte, parent jsonSpan, children []jsonSpan, def string) string {
+	if len(children) == 0 {
+		return def
+	}
+	outer := lineIndent(data, parent.start)
+	inner := lineIndent(data, children[0].keyStart)
+	if len(inner) > len(outer) && strings.HasPrefix(inner, outer) {
+		return inner[len(outer):]
+	}
+	return def
+}
+
+// formatList formats list like old, the value it replaces in data: on one
+// line if old was, and otherwise with one element per line.
+func formatList(data []byte, old jsonSpan, list []json.RawMessage) []byte {
+	oldText := data[old.start:old.end]
+	if !bytes.Contains(oldText, []byte("\n")) || len(list) == 0 {
+		var b bytes.Buffer
+		b.WriteByte('[')
+		for i, v := range list {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.Write(v)
+		}
+		b.WriteByte(']')
+		return b.Bytes()
+	}
+	indent := lineIndent(data, old.start)
+	unit := "\t"
+	if nl := bytes.IndexByte(oldText, '\n'); nl >= 0 {
+		if inner := lineIndent(oldText, nl+1); len(inner) > len(indent) && strings.HasPrefix(inner, indent) {
+			unit = inner[len(indent):]
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("[\n")
+	for i, v := range list {
+		b.WriteString(indent + unit)
+		b.Write(v)
+		if i < len(list)-1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + "]")
+	return b.Bytes()
+}
+
+// insertChild returns data, with text added after the last of children, the
+// fields or the elements of the JSON object or array that is parent. text is
+// separated from the last one as the last one is from the one before.
+func insertChild(data []byte, parent jsonSpan, children []jsonSpan, text []byte) []byte {
+	after, sep := parent.start+1, ""
+	switch n := len(children); {
+	case n > 1:
+		after = children[n-1].end
+		sep = string(data[children[n-2].end:children[n-1].keyStart])
+	case n == 1:
+		after = children[0].end
+		sep = "," + string(data[parent.start+1:children[0].keyStart])
+		if sep == "," {
+			sep = ", "
+		}
+	}
+	var out []byte
+	out = append(out, data[:after]...)
+	out = append(out, sep...)
+	out = append(out, text...)
+	return append(out, data[after:]...)
+}
+
+// setList returns data, with the list value of the field key of the project
+// that is p, with fields, replaced by list. The field is added after the
+// last one if needed. Everything else in data is kept as it is.
+func setList(data []byte, p jsonSpan, fields []jsonSpan, key string, list []json.RawMessage) []byte {
+	if f, ok := field(fields, key); ok {
+		var out []byte
+		out = append(out, data[:f.start]...)
+		out = append(out, formatList(data, f, list)...)
+		return append(out, data[f.end:]...)
+	}
+	quoted, _ := marshalIndent(key, "", "")
+	text := append(quoted, ": "...)
+	text = append(text, formatList(data, jsonSpan{}, list)...)
+	return insertChild(data, p, fields, text)
+}
+
+// editProject runs the edit command q.mode, with the argument q.what, on the
+// project q.project: it changes the project in the configuration file, which
+// the watcher of the file then loads again, as for any other change.
+func editProject(q *query) error {
+	return editConfig(configFile, q)
+}
+
+// editConfig changes the project q.project in the configuration file, as the
+// edit command q.mode says.
+func editConfig(file string, q *query) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(abs)
+	data, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return err
+	}
+	p, fields, err := projectFields(data, q.project)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+	_, extends := field(fields, "Extends")
+	what := strings.TrimSpace(q.what)
+
+	// The list to change, with its current elements.
+	key := "Locations"
+	if q.mode == excludeCmd {
+		key = "Excluded"
+	}
+	var list []json.RawMessage
+	if f, ok := field(fields, key); ok {
+		if err := json.Unmarshal(data[f.start:f.end], &list); err != nil {
+			return err
+		}
+	} else if extends {
+		// keep on inheriting them.
+		list = []json.RawMessage{json.RawMessage(`"` + inheritMarker + `"`)}
+	}
+
+	switch q.mode {
+	case addLocCmd:
+		path, err := expandPath(what, dir)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%v is not a directory", path)
+		}
+		if _, ok := projects[q.project].location(path); ok {
+			return fmt.Errorf("%v is already a location of %v", path, q.project)
+		}
+		v, _ := marshalIndent(what, "", "")
+		list = append(list, v)
+	case excludeCmd:
+		if _, err := compilePattern(what); err != nil {
+			return err
+		}
+		v, _ := marshalIndent(what, "", "")
+		list = append(list, v)
+	case delLocCmd:
+		found := false
+		for i, v := range list {
+			var l Location
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			path, err := expandPath(l.Path, dir)
+			if err == nil && l.Path != inheritMarker && (filepath.Clean(path) == filepath.Clean(what) || l.Label != "" && l.Label == what) {
+				list = append(list[:i], list[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			if _, ok := projects[q.project].location(what); ok && extends {
+				return fmt.Errorf("%v is inherited, from project %v", what, projects[q.project].Extends)
+			}
+			return fmt.Errorf("%v is not a location of %v", what, q.project)
+		}
+		if len(list) == 0 && !extends {
+			return fmt.Errorf("%v is the only location of %v", what, q.project)
+		}
+	default:
+		return fmt.Errorf("unknown edit command %v", q.mode)
+	}
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(abs, setList(data, p, fields, key, list), fi.Mode().Perm())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandVars expands the environment variables, as $VAR or ${VAR}, in s. An
+// unset variable is an error, except for GOPATH and GOMODCACHE, which default
+// to what the go command uses. GOPATH is only its first directory.
+func expandVars(s string) (string, error) {
+	var err error
+	expanded := os.Expand(s, func(name string) string {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			if name == "GOPATH" {
+				return filepath.SplitList(v)[0]
+			}
+			return v
+		}
+		switch name {
+		case "GOPATH":
+			return goPath()
+		case "GOMODCACHE":
+			return filepath.Join(goPath(), "pkg", "mod")
+		}
+		if _, ok := os.LookupEnv(name); !ok && err == nil {
+			err = fmt.Errorf("$%s is not set", name)
+		}
+		return ""
+	})
+	return expanded, err
+}
+
+// goPath returns the first directory of GOPATH, or its default.
+func goPath() string {
+	if list := filepath.SplitList(build.Default.GOPATH); len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
+
+// expandPath expands the environment variables, and a leading ~, in the path
+// p. If dir is not empty, a relative p is made relative to dir.
+func expandPath(p, dir string) (string, error) {
+	p, err := expandVars(p)
+	if err != nil {
+		return "", err
+	}
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = home + p[1:]
+	}
+	if dir != "" && p != "" && !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return p, nil
+}
+
+// expandConfig expands the paths in conf, whose file is in dir: the paths of
+// the Locations, which are relative to dir, and the GuruScope and Tags. It
+// returns the errors found, along with their JSON path.
+func expandConfig(conf *config, dir string) []configError {
+	var errs []configError
+	addErr := func(path string, err error) {
+		errs = append(errs, configError{Path: path, Msg: err.Error()})
+	}
+	for i := range conf.Projects {
+		p := &conf.Projects[i]
+		path := conf.projectPaths[i]
+		for j := range p.Locations {
+			if p.Locations[j].Path == inheritMarker {
+				continue
+			}
+			expanded, err := expandPath(p.Locations[j].Path, dir)
+			if err != nil {
+				addErr(fmt.Sprintf("%s.Locations[%d]", path, j), err)
+				continue
+			}
+			p.Locations[j].Path = expanded
+		}
+		for j, v := range p.GuruScope {
+			expanded, err := expandVars(v)
+			if err != nil {
+				addErr(fmt.Sprintf("%s.GuruScope[%d]", path, j), err)
+				continue
+			}
+			p.GuruScope[j] = expanded
+		}
+		if len(p.Tags) == 0 {
+			continue
+		}
+		// The keys are Locations, and the values are relative to them.
+		var locs []string
+		for loc := range p.Tags {
+			locs = append(locs, loc)
+		}
+		sort.Strings(locs)
+		tags := make(map[string]string, len(p.Tags))
+		for _, loc := range locs {
+			tf := p.Tags[loc]
+			expandedLoc, err := expandPath(loc, dir)
+			if err != nil {
+				addErr(fmt.Sprintf("%s.Tags[%q]", path, loc), err)
+				continue
+			}
+			expanded, err := expandPath(tf, "")
+			if err != nil {
+				addErr(fmt.Sprintf("%s.Tags[%q]", path, loc), err)
+				continue
+			}
+			tags[expandedLoc] = expanded
+		}
+		p.Tags = tags
+	}
+	return errs
+}
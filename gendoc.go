@@ -0,0 +1,79 @@
+//go:build ignore
+
+// The gendoc program writes the program doc, in doc.go, and README.txt, from
+// docTxt in main.go, so that they always say the same thing.
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	doc, err := docTxt("main.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var src strings.Builder
+	src.WriteString("// Copyright 2011 Mathieu Lonjaret\n\n")
+	src.WriteString("// Code generated by gendoc.go from docTxt in main.go. DO NOT EDIT.\n\n")
+	for _, line := range strings.Split(doc, "\n") {
+		switch {
+		case line == "":
+			src.WriteString("//\n")
+		case strings.HasPrefix(line, "\t"):
+			src.WriteString("//" + line + "\n")
+		default:
+			src.WriteString("// " + line + "\n")
+		}
+	}
+	src.WriteString("package main\n")
+	formatted, err := format.Source([]byte(src.String()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("doc.go", formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("README.txt", []byte("gofinder\n========\n\n"+doc+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// docTxt returns the value of docTxt in the Go file name, without its
+// surrounding blank lines.
+func docTxt(name string) (string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if len(vs.Names) != 1 || vs.Names[0].Name != "docTxt" || len(vs.Values) != 1 {
+				continue
+			}
+			lit, ok := vs.Values[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				break
+			}
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return "", err
+			}
+			return strings.Trim(s, "\n"), nil
+		}
+	}
+	return "", fmt.Errorf("no docTxt string in %v", name)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// globPrefix marks the Exts and Excluded patterns that are globs, in the
+// gitignore style, rather than regexps.
+const globPrefix = "glob:"
+
+// isGlob reports whether pattern is a glob, and returns it without its prefix.
+func isGlob(pattern string) (string, bool) {
+	if !strings.HasPrefix(pattern, globPrefix) {
+		return pattern, false
+	}
+	return strings.TrimPrefix(pattern, globPrefix), true
+}
+
+// globRegexp translates glob to a regexp that matches the paths, relative to
+// a location, that glob matches. As with gitignore: a glob without a slash
+// matches a name at any depth, "**" matches any number of directories, and a
+// glob that matches a directory also matches everything below it.
+func globRegexp(glob string) (string, error) {
+	g := strings.TrimSuffix(glob, "/")
+	if g == "" {
+		return "", errors.New("empty glob")
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(g, "/") {
+		b.WriteString("(.*/)?")
+	}
+	g = strings.TrimPrefix(g, "/")
+	for i := 0; i < len(g); i++ {
+		c := g[i]
+		switch {
+		case strings.HasPrefix(g[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(g[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\':
+			if i+1 == len(g) {
+				return "", errors.New("trailing backslash in glob")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		case c == '[':
+			class, n, err := globClass(g[i:])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		}
+	}
+	b.WriteString("(/.*)?$")
+	return b.String(), nil
+}
+
+// posixClasses are the character classes, as in [[:alpha:]], that a glob
+// can have within brackets.
+var posixClasses = map[string]bool{
+	"alnum": true, "alpha": true, "ascii": true, "blank": true, "cntrl": true,
+	"digit": true, "graph": true, "lower": true, "print": true, "punct": true,
+	"space": true, "upper": true, "word": true, "xdigit": true,
+}
+
+// globClass translates the bracket expression that g starts with to a regexp
+// character class, and returns it along with its length in g. A ] right after
+// the opening bracket (and ! or ^) is one of the characters, and a negated
+// class does not match a slash either.
+func globClass(g string) (string, int, error) {
+	j := 1
+	negated := j < len(g) && (g[j] == '!' || g[j] == '^')
+	if negated {
+		j++
+	}
+	var class strings.Builder
+	for start := j; j < len(g); j++ {
+		c := g[j]
+		switch {
+		case c == ']' && j > start:
+			if negated {
+				return "[^/" + class.String() + "]", j + 1, nil
+			}
+			return "[" + class.String() + "]", j + 1, nil
+		case strings.HasPrefix(g[j:], "[:"):
+			end := strings.Index(g[j+2:], ":]")
+			if end < 0 {
+				return "", 0, errors.New("missing :] in glob")
+			}
+			name := g[j+2 : j+2+end]
+			if !posixClasses[name] {
+				return "", 0, fmt.Errorf("unknown character class [:%s:] in glob", name)
+			}
+			class.WriteString("[:" + name + ":]")
+			j += end + 3
+		case c == '\\':
+			if j+1 == len(g) {
+				return "", 0, errors.New("trailing backslash in glob")
+			}
+			j++
+			class.WriteString(regexp.QuoteMeta(g[j : j+1]))
+		case c == ']' || c == '[':
+			class.WriteString(`\` + string(c))
+		default:
+			class.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("missing ] in glob")
+}
+
+// compilePattern compiles the Exts or Excluded pattern, be it a regexp or a
+// glob.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if g, ok := isGlob(pattern); ok {
+		return compileGlob(g)
+	}
+	return regexp.Compile(pattern)
+}
+
+// pathFilter selects the files of a search, with the Exts and Excluded
+// patterns. The regexps are matched against the whole path, as with find
+// -regex, and the globs against the path relative to its location.
+type pathFilter struct {
+	extRegexps  []string
+	exclRegexps []string
+	ext         *regexp.Regexp // the extRegexps, nil if none
+	excl        []*regexp.Regexp
+	extGlobs    []*regexp.Regexp
+	exclGlobs   []*regexp.Regexp
+}
+
+func newPathFilter(exts, excl []string) (*pathFilter, error) {
+	f := &pathFilter{}
+	for _, v := range exts {
+		g, ok := isGlob(v)
+		if !ok {
+			f.extRegexps = append(f.extRegexps, v)
+			continue
+		}
+		rx, err := compileGlob(g)
+		if err != nil {
+			return nil, err
+		}
+		f.extGlobs = append(f.extGlobs, rx)
+	}
+	if len(f.extRegexps) > 0 {
+		rx, err := regexp.Compile(`^.*(` + strings.Join(f.extRegexps, "|") + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		f.ext = rx
+	}
+	for _, v := range excl {
+		g, ok := isGlob(v)
+		if !ok {
+			rx, err := regexp.Compile(`^(` + v + `)$`)
+			if err != nil {
+				return nil, err
+			}
+			f.exclRegexps = append(f.exclRegexps, v)
+			f.excl = append(f.excl, rx)
+			continue
+		}
+		rx, err := compileGlob(g)
+		if err != nil {
+			return nil, err
+		}
+		f.exclGlobs = append(f.exclGlobs, rx)
+	}
+	return f, nil
+}
+
+func compileGlob(g string) (*regexp.Regexp, error) {
+	exp, err := globRegexp(g)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(exp)
+}
+
+// hasGlobs reports whether f has globs, that find can't match.
+func (f *pathFilter) hasGlobs() bool {
+	return len(f.extGlobs) > 0 || len(f.exclGlobs) > 0
+}
+
+// findExts returns the regexp that find should select the files with. It
+// selects all of them if there are globs in the exts.
+func (f *pathFilter) findExts() string {
+	if len(f.extGlobs) > 0 {
+		return ".*"
+	}
+	return ".*(" + strings.Join(f.extRegexps, "|") + ")$"
+}
+
+// selects reports whether path, below the location loc, has one of the exts,
+// and is not excluded.
+func (f *pathFilter) selects(loc, path string) bool {
+	rel, err := filepath.Rel(loc, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+	ok := f.ext != nil && f.ext.MatchString(path)
+	for _, rx := range f.extGlobs {
+		if ok {
+			break
+		}
+		ok = rx.MatchString(rel)
+	}
+	if !ok {
+		return false
+	}
+	for _, rx := range f.excl {
+		if rx.MatchString(path) {
+			return false
+		}
+	}
+	for _, rx := range f.exclGlobs {
+		if rx.MatchString(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// locationOf returns the one of locations that path is below.
+func locationOf(locations []string, path string) string {
+	var loc string
+	for _, l := range locations {
+		if isBelow(path, l) && len(l) > len(loc) {
+			loc = l
+		}
+	}
+	return loc
+}
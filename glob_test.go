@@ -0,0 +1,55 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	for _, tt := range []struct {
+		glob     string
+		match    []string
+		notMatch []string
+	}{
+		{"*.go", []string{"a.go", "x/a.go", "x/a.go/b"}, []string{"a.gox", "go"}},
+		{"**/x/**", []string{"x/a", "a/b/x/c", "x/a/b"}, []string{"x", "ax/b", "a/xb/c"}},
+		{"/anchored", []string{"anchored", "anchored/f"}, []string{"a/anchored", "anchoredx"}},
+		{"a/*/c", []string{"a/b/c", "a/b/c/d"}, []string{"a/c", "a/b/b/c", "x/a/b/c"}},
+		{"[!a]", []string{"b", "d/b", "-"}, []string{"a", "ab", "a/a"}},
+		{"[]]", []string{"]", "d/]"}, []string{"a", "]]"}},
+		{"[!]]", []string{"a"}, []string{"]"}},
+		{"[[:alpha:]]", []string{"q", "d/Q"}, []string{"1", "[", ":", "qq"}},
+		{"[[:digit:]x-z]", []string{"1", "y"}, []string{"a", "-"}},
+		{`[\]a]`, []string{"]", "a"}, []string{`\`, "b"}},
+		{"a?c", []string{"abc"}, []string{"a/c", "ac"}},
+	} {
+		exp, err := globRegexp(tt.glob)
+		if err != nil {
+			t.Errorf("globRegexp(%q): %v", tt.glob, err)
+			continue
+		}
+		rx, err := regexp.Compile(exp)
+		if err != nil {
+			t.Errorf("globRegexp(%q) = %q: %v", tt.glob, exp, err)
+			continue
+		}
+		for _, path := range tt.match {
+			if !rx.MatchString(path) {
+				t.Errorf("glob %q (%q) does not match %q", tt.glob, exp, path)
+			}
+		}
+		for _, path := range tt.notMatch {
+			if rx.MatchString(path) {
+				t.Errorf("glob %q (%q) matches %q", tt.glob, exp, path)
+			}
+		}
+	}
+}
+
+func TestGlobRegexpErrors(t *testing.T) {
+	for _, glob := range []string{"", "/", "[", "[]", "[!]", "[[:alpha:]", "[[:nope:]]", "[[:alpha]", `a\`, `[a\`} {
+		if exp, err := globRegexp(glob); err == nil {
+			t.Errorf("globRegexp(%q) = %q, want an error", glob, exp)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// serveHTTP serves the web UI, on localhost:port. It is for the ones who do not
+// use acme, and it searches in the projects of our configuration file.
+func serveHTTP(port string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", httpHome)
+	mux.HandleFunc("/search", httpSearch)
+	mux.HandleFunc("/file", httpFile)
+	return http.ListenAndServe(net.JoinHostPort("localhost", port), localOnly(mux))
+}
+
+// localOnly refuses the requests that were not addressed to localhost, so
+// that some web page can't use DNS rebinding to read our files.
+func localOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, hr *http.Request) {
+		host, _, err := net.SplitHostPort(hr.Host)
+		if err != nil {
+			host = hr.Host
+		}
+		if host != "localhost" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+				http.Error(rw, "forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(rw, hr)
+	})
+}
+
+type httpKind struct {
+	Value string
+	Label string
+}
+
+type httpProject struct {
+	Name      string
+	Locations []Location
+	Kinds     []httpKind
+}
+
+// searchKinds returns the kinds of search that the web UI offers for p: the
+// same ones as the acme UI, except for the ones that are about jumping to a
+// file.
+func searchKinds(p Project) []httpKind {
+	kinds := []httpKind{{"text", "text"}, {"regexp", "regexp"}}
+	for _, l := range projectLanguages(p, configOf(nil).languages) {
+		for _, d := range l.sortedDecls() {
+			kinds = append(kinds, httpKind{"decl:" + l.Name + ":" + d, l.Name + " " + d})
+		}
+	}
+	var modes []string
+	for mode := range guruModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
+		kinds = append(kinds, httpKind{"guru:" + mode, "guru " + mode})
+	}
+	return kinds
+}
+
+func httpHome(rw http.ResponseWriter, hr *http.Request) {
+	if hr.URL.Path != "/" {
+		http.NotFound(rw, hr)
+		return
+	}
+	var projs []httpProject
+	for _, p := range configOf(nil).projects {
+		projs = append(projs, httpProject{Name: p.Name, Locations: p.Locations, Kinds: searchKinds(p)})
+	}
+	sort.Slice(projs, func(i, j int) bool { return projs[i].Name < projs[j].Name })
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	httpTemplates.ExecuteTemplate(rw, "home", projs)
+}
+
+type searchPage struct {
+	Project string
+	What    string
+	Kind    string
+	Where   string
+}
+
+func httpSearch(rw http.ResponseWriter, hr *http.Request) {
+	page := searchPage{
+		Project: hr.FormValue("project"),
+		What:    hr.FormValue("q"),
+		Kind:    hr.FormValue("kind"),
+		Where:   hr.FormValue("where"),
+	}
+	p, ok := configOf(nil).projects[page.Project]
+	if !ok {
+		http.Error(rw, "unknown project "+page.Project, http.StatusNotFound)
+		return
+	}
+	if page.What == "" {
+		http.Redirect(rw, hr, "/", http.StatusFound)
+		return
+	}
+	if page.Where != "" && !isLocation(p, page.Where) {
+		http.Error(rw, page.Where+" is not a location of "+page.Project, http.StatusBadRequest)
+		return
+	}
+	m := request{
+		Version: protocolVersion,
+		ID:      newRequestID(),
+		Project: page.Project,
+		Where:   page.Where,
+	}
+	switch {
+	case page.Kind == "text":
+		m.Action = regex
+		m.What = escapeSpecials(page.What)
+	case page.Kind == "regexp":
+		m.Action = regex
+		m.What = page.What
+	case strings.HasPrefix(page.Kind, "decl:"):
+		parts := strings.SplitN(page.Kind, ":", 3)
+		if len(parts) != 3 {
+			http.Error(rw, "bad declaration kind "+page.Kind, http.StatusBadRequest)
+			return
+		}
+		m.Action = decl
+		m.Lang, m.Decl = parts[1], parts[2]
+		m.What = escapeSpecials(page.What)
+	case strings.HasPrefix(page.Kind, "guru:"):
+		if _, ok := guruModes[strings.TrimPrefix(page.Kind, "guru:")]; !ok {
+			http.Error(rw, "bad guru mode "+page.Kind, http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(rw, "bad kind of search "+page.Kind, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := httpTemplates.ExecuteTemplate(rw, "header", page); err != nil {
+		return
+	}
+	if strings.HasPrefix(page.Kind, "guru:") {
+		httpGuru(rw, strings.TrimPrefix(page.Kind, "guru:"), page.What, page.Project)
+		return
+	}
+	flush(rw)
+	// The results are streamed as they come, and the search stops if the
+	// browser goes away.
+	searchInProcess(m, func(resp response) error {
+		var err error
+		switch resp.Kind {
+		case responseResults:
+			for _, mt := range resp.Matches {
+				if err = httpTemplates.ExecuteTemplate(rw, "match", mt); err != nil {
+					return err
+				}
+			}
+		case responseError:
+			err = httpTemplates.ExecuteTemplate(rw, "error", resp.Err)
+		case responseDone:
+			err = httpTemplates.ExecuteTemplate(rw, "footer", resp.Summary)
+		}
+		flush(rw)
+		return err
+	})
+}
+
+// guruPosition matches the position at the start of a line of guru output.
+var guruPosition = regexp.MustCompile(`^(/[^:]*):([0-9]+)[.:][^ ]*:?`)
+
+func httpGuru(rw http.ResponseWriter, mode, loc, project string) {
+	_, out, err := runGuru(mode, loc, project)
+	if err != nil {
+		httpTemplates.ExecuteTemplate(rw, "error", err)
+		httpTemplates.ExecuteTemplate(rw, "footer", nil)
+		return
+	}
+	for _, line := range strings.Split(string(bytes.TrimSpace(out)), "\n") {
+		mt := match{Text: line}
+		if sm := guruPosition.FindStringSubmatch(line); sm != nil {
+			n, _ := strconv.Atoi(sm[2])
+			mt = match{File: sm[1], Line: n, Text: strings.TrimPrefix(line, sm[0])}
+		}
+		httpTemplates.ExecuteTemplate(rw, "match", mt)
+	}
+	httpTemplates.ExecuteTemplate(rw, "footer", nil)
+}
+
+// searchInProcess runs m with our own server, and calls fn with each response,
+// until the terminal one, or until fn fails.
+func searchInProcess(m request, fn func(response) error) error {
+	server, conn := net.Pipe()
+	go serve(server)
+	defer conn.Close()
+	if err := gob.NewEncoder(conn).Encode(m); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(conn)
+	for {
+		var resp response
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+		if err := fn(resp); err != nil {
+			return err
+		}
+		if resp.Kind == responseDone {
+			return nil
+		}
+	}
+}
+
+func flush(rw http.ResponseWriter) {
+	if f, ok := rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func isLocation(p Project, where string) bool {
+	_, ok := p.location(where)
+	return ok
+}
+
+// inLocations reports whether the file name, whose symlinks are resolved, is
+// below a location of one of the projects, which are the only files we show.
+// The symlinks of the locations are resolved too, and a location for which
+// that fails contains nothing.
+func inLocations(name string) bool {
+	for _, p := range configOf(nil).projects {
+		for _, l := range p.paths() {
+			l, err := filepath.EvalSymlinks(l)
+			if err != nil {
+				continue
+			}
+			if isBelow(name, l) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type sourceLine struct {
+	N     int
+	Text  string
+	Match bool
+}
+
+type filePage struct {
+	Name  string
+	Lines []sourceLine
+}
+
+func httpFile(rw http.ResponseWriter, hr *http.Request) {
+	name := filepath.Clean(hr.FormValue("path"))
+	// A symlink in a location could point anywhere, so what is checked, and
+	// read, is where it leads.
+	real, err := filepath.EvalSymlinks(name)
+	if !filepath.IsAbs(name) || err != nil || !inLocations(real) {
+		http.Error(rw, name+" is not in a project location", http.StatusForbidden)
+		return
+	}
+	data, err := ioutil.ReadFile(real)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	line, _ := strconv.Atoi(hr.FormValue("line"))
+	page := filePage{Name: name}
+	for i, text := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		page.Lines = append(page.Lines, sourceLine{N: i + 1, Text: text, Match: i+1 == line})
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	httpTemplates.ExecuteTemplate(rw, "file", page)
+}
+
+var httpTemplates = template.Must(template.New("").Parse(`
+{{define "style"}}<style>
+body { font-family: sans-serif; }
+pre, .results { font-family: monospace; }
+.results { list-style: none; padding: 0; }
+.error { color: #a00; }
+table { border-collapse: collapse; }
+td { vertical-align: top; padding: 0 0.5em; }
+td pre { margin: 0; }
+tr.match { background: #ff8; }
+td.n a { color: #888; text-decoration: none; }
+</style>{{end}}
+
+{{define "home"}}<!DOCTYPE html>
+<html><head><title>gofinder</title>{{template "style"}}</head><body>
+{{range .}}
+<h2>{{.Name}}</h2>
+<form action="/search">
+<input type="hidden" name="project" value="{{.Name}}">
+<input name="q" size="40" placeholder="what (file.go:#offset, for guru)">
+<select name="kind">{{range .Kinds}}<option value="{{.Value}}">{{.Label}}</option>{{end}}</select>
+<select name="where"><option value="">all locations</option>{{range .Locations}}<option value="{{.Path}}">{{or .Label .Path}}</option>{{end}}</select>
+<input type="submit" value="Search">
+</form>
+<ul>{{range .Locations}}<li>{{with .Label}}{{.}}: {{end}}{{.Path}}</li>{{end}}</ul>
+{{else}}
+<p>No projects.</p>
+{{end}}
+</body></html>
+{{end}}
+
+{{define "header"}}<!DOCTYPE html>
+<html><head><title>{{.What}} - gofinder</title>{{template "style"}}</head><body>
+<p><a href="/">gofinder</a>: {{.Kind}} search for <b>{{.What}}</b> in {{.Project}}{{with .Where}} ({{.}}){{end}}</p>
+<ul class="results">
+{{end}}
+
+{{define "match"}}<li>{{if .File}}<a href="/file?path={{.File}}&amp;line={{.Line}}#L{{.Line}}">{{.File}}{{if .Line}}:{{.Line}}{{end}}</a>:{{end}}{{.Text}}</li>
+{{end}}
+
+{{define "error"}}<li class="error">{{.}}</li>
+{{end}}
+
+{{define "footer"}}</ul>
+{{with .}}<p>{{.}}</p>{{end}}
+</body></html>
+{{end}}
+
+{{define "file"}}<!DOCTYPE html>
+<html><head><title>{{.Name}} - gofinder</title>{{template "style"}}</head><body>
+<p><a href="/">gofinder</a>: {{.Name}}</p>
+<table>
+{{range .Lines}}<tr id="L{{.N}}"{{if .Match}} class="match"{{end}}><td class="n"><a href="#L{{.N}}">{{.N}}</a></td><td><pre>{{.Text}}</pre></td></tr>
+{{end}}</table>
+</body></html>
+{{end}}
+`))
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// inheritMarker, as an element of the Locations, Exts, Excluded, or GuruScope
+// of a project, stands for the ones of the project it extends.
+const inheritMarker = "..."
+
+// configLoader reads a configuration file, and the ones it includes, into
+// conf.
+type configLoader struct {
+	conf config
+	// errs are the errors found in the files, along with their JSON path.
+	errs []configError
+	// warns are the warnings about the files, along with their JSON path.
+	warns []configError
+	// loaded are the files already read, so that a file included twice only
+	// counts once.
+	loaded map[string]bool
+}
+
+// load reads file, and the files it includes, and adds their languages and
+// projects to ld.conf. prefix is added to the JSON paths of file, and stack
+// is the chain of files that include file. Only a top file that can't be
+// read, or decoded, is a failure. The other errors are recorded in ld.errs,
+// and the warnings in ld.warns.
+func (ld *configLoader) load(file, prefix string, stack []string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	ld.loaded[abs] = true
+	data, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return err
+	}
+	conf, legacy, err := decodeConfig(data)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, jsonError(data, err))
+	}
+	var unknown []configError
+	if legacy {
+		unknown = unknownFields(data, 0, len(data), reflect.TypeOf(conf.Projects), "")
+	} else {
+		unknown = unknownFields(data, 0, len(data), reflect.TypeOf(conf), "")
+	}
+	for _, w := range unknown {
+		ld.warns = append(ld.warns, configError{Path: prefix + w.Path, Msg: w.Msg})
+	}
+	for i := range conf.Languages {
+		conf.languagePaths = append(conf.languagePaths, fmt.Sprintf("%sLanguages[%d]", prefix, i))
+	}
+	for i := range conf.Projects {
+		path := fmt.Sprintf("%sProjects[%d]", prefix, i)
+		if legacy {
+			path = fmt.Sprintf("%s[%d]", prefix, i)
+		}
+		conf.projectPaths = append(conf.projectPaths, path)
+		conf.projectFiles = append(conf.projectFiles, abs)
+	}
+	dir := filepath.Dir(abs)
+	ld.errs = append(ld.errs, expandConfig(&conf, dir)...)
+
+	stack = append(stack, abs)
+	for i, inc := range conf.Include {
+		path := fmt.Sprintf("%sInclude[%d]", prefix, i)
+		included, err := expandPath(inc, dir)
+		if err != nil {
+			ld.errs = append(ld.errs, configError{Path: path, Msg: err.Error()})
+			continue
+		}
+		included = filepath.Clean(included)
+		if cycle := includeCycle(stack, included); cycle != "" {
+			ld.errs = append(ld.errs, configError{Path: path, Msg: "include cycle: " + cycle})
+			continue
+		}
+		if ld.loaded[included] {
+			continue
+		}
+		if err := ld.load(included, included+": ", stack); err != nil {
+			ld.errs = append(ld.errs, configError{Path: path, Msg: err.Error()})
+		}
+	}
+
+	ld.conf.Languages = append(ld.conf.Languages, conf.Languages...)
+	ld.conf.languagePaths = append(ld.conf.languagePaths, conf.languagePaths...)
+	ld.conf.Projects = append(ld.conf.Projects, conf.Projects...)
+	ld.conf.projectPaths = append(ld.conf.projectPaths, conf.projectPaths...)
+	ld.conf.projectFiles = append(ld.conf.projectFiles, conf.projectFiles...)
+	return nil
+}
+
+// includeCycle returns the chain of files, from stack, that leads to file
+// again, or "" if file is not in stack.
+func includeCycle(stack []string, file string) string {
+	for i, v := range stack {
+		if v == file {
+			return strings.Join(append(stack[i:len(stack):len(stack)], file), " -> ")
+		}
+	}
+	return ""
+}
+
+// inheritProjects gives to the projects of conf that extend another one what
+// they inherit from it, and returns the errors found, along with their JSON
+// path.
+func inheritProjects(conf *config) []configError {
+	var errs []configError
+	addErr := func(path, format string, args ...interface{}) {
+		errs = append(errs, configError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	byName := make(map[string]int, len(conf.Projects))
+	for i, p := range conf.Projects {
+		if _, ok := byName[p.Name]; !ok {
+			byName[p.Name] = i
+		}
+	}
+	const (
+		unresolved = iota
+		resolving
+		resolved
+	)
+	state := make([]int, len(conf.Projects))
+	var resolve func(i int, chain []string)
+	resolve = func(i int, chain []string) {
+		p := &conf.Projects[i]
+		path := conf.projectPaths[i]
+		state[i] = resolving
+		defer func() { state[i] = resolved }()
+		if p.Extends == "" {
+			if hasInheritMarker(p) {
+				addErr(path, "%q can only be used by a project that extends another one", inheritMarker)
+			}
+			return
+		}
+		chain = append(chain, p.Name)
+		// When the base is in error, the inheritMarker stands for nothing,
+		// so that only its error shows.
+		var base Project
+		b, ok := byName[p.Extends]
+		switch {
+		case !ok:
+			addErr(path+".Extends", "unknown project %q", p.Extends)
+		case state[b] == resolving:
+			addErr(path+".Extends", "cycle: %s -> %s", strings.Join(chain, " -> "), p.Extends)
+		default:
+			if state[b] == unresolved {
+				resolve(b, chain)
+			}
+			base = conf.Projects[b]
+		}
+		p.Locations = inheritLocations(p.Locations, base.Locations)
+		p.Exts = inheritList(p.Exts, base.Exts)
+		p.Excluded = inheritList(p.Excluded, base.Excluded)
+		p.GuruScope = inheritList(p.GuruScope, base.GuruScope)
+	}
+	for i := range conf.Projects {
+		if state[i] == unresolved {
+			resolve(i, nil)
+		}
+	}
+	return errs
+}
+
+// inheritList returns own if it is set, with the inheritMarker replaced by
+// base, and base otherwise.
+func inheritList(own, base []string) []string {
+	if own == nil {
+		return append([]string(nil), base...)
+	}
+	var list []string
+	for _, v := range own {
+		if v == inheritMarker {
+			list = append(list, base...)
+			continue
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
+// inheritLocations is inheritList, for Locations.
+func inheritLocations(own, base []Location) []Location {
+	if own == nil {
+		return append([]Location(nil), base...)
+	}
+	var list []Location
+	for _, v := range own {
+		if v.Path == inheritMarker {
+			list = append(list, base...)
+			continue
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
+// hasInheritMarker reports whether one of the lists of p uses the
+// inheritMarker.
+func hasInheritMarker(p *Project) bool {
+	for _, l := range p.Locations {
+		if l.Path == inheritMarker {
+			return true
+		}
+	}
+	for _, list := range [][]string{p.Exts, p.Excluded, p.GuruScope} {
+		for _, v := range list {
+			if v == inheritMarker {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openKeyword is the UI command, on the line of a language that has Resolve
+// rules, that opens the file an import path refers to.
+const openKeyword = "open"
+
+// jsIdentEnd matches what can follow the end of a JavaScript identifier.
+const jsIdentEnd = `([^[:alnum:]_$]|$)`
+
+// jsDecls returns the declaration patterns common to JavaScript and
+// TypeScript.
+func jsDecls() map[string]string {
+	return map[string]string{
+		"func":   `^[[:space:]]*(export[[:space:]]+)?(default[[:space:]]+)?(async[[:space:]]+)?function[[:space:]]*\*?[[:space:]]*{{word}}[[:space:]]*[(<]`,
+		"class":  `^[[:space:]]*(export[[:space:]]+)?(default[[:space:]]+)?(abstract[[:space:]]+)?class[[:space:]]+{{word}}` + jsIdentEnd,
+		"meth":   `^[[:space:]]*((static|async|get|set|public|private|protected|readonly|override)[[:space:]]+)*\*?#?{{word}}[[:space:]]*(<[^>]*>)?\([^;]*$`,
+		"arrow":  `(const|let|var)[[:space:]]+{{word}}[[:space:]]*(:[^=]*)?=[[:space:]]*(async[[:space:]]*)?(\([^)]*\)|[[:alnum:]_$]+)[[:space:]]*(:[^=]*)?=>`,
+		"export": `^[[:space:]]*export([[:space:]]|\{)(.*[^[:alnum:]_$])?{{word}}` + jsIdentEnd,
+		"import": `^[[:space:]]*import([[:space:]]|\{)(.*[^[:alnum:]_$])?{{word}}` + jsIdentEnd + `|require\([^)]*{{word}}`,
+	}
+}
+
+func tsDecls() map[string]string {
+	decls := jsDecls()
+	decls["type"] = `^[[:space:]]*(export[[:space:]]+)?(declare[[:space:]]+)?(type|interface|enum)[[:space:]]+{{word}}` + jsIdentEnd
+	return decls
+}
+
+// resolveImport returns the path of the file that the module import path spec
+// refers to, or "" if it was not found. A relative spec is resolved from the
+// directory of from, the importing file. Any other spec is looked for in
+// locations, first directly and then below them, like for any other file. For
+// each candidate path, each of the suffixes is tried in turn.
+func resolveImport(spec, from string, suffixes, locations []string) string {
+	spec = strings.Trim(spec, "\"'`;")
+	if spec == "" {
+		return ""
+	}
+	relative := strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../")
+	var bases []string
+	switch {
+	case relative && from != "":
+		bases = []string{filepath.Join(filepath.Dir(from), spec)}
+	case filepath.IsAbs(spec):
+		bases = []string{spec}
+	default:
+		for _, l := range locations {
+			bases = append(bases, filepath.Join(l, spec))
+		}
+	}
+	for _, base := range bases {
+		for _, suffix := range suffixes {
+			if isFile(base + suffix) {
+				return base + suffix
+			}
+		}
+	}
+	if relative || filepath.IsAbs(spec) {
+		return ""
+	}
+	for _, suffix := range suffixes {
+		if suffix == "" {
+			continue
+		}
+		if found := findFile(spec+suffix, locations); found != "" && isFile(found) {
+			return found
+		}
+	}
+	return ""
+}
+
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// locFile returns the file part of an acme address such as
+// "/path/to/file.js:#10,#20".
+func locFile(loc string) string {
+	if i := strings.LastIndex(loc, ":"); i > 0 {
+		return loc[:i]
+	}
+	return loc
+}
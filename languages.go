@@ -0,0 +1,228 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// toGoKeyword is the command, on the line of a language that is compiled to,
+// or that implements, Go code (proto and asm), that finds the corresponding
+// Go declarations.
+const toGoKeyword = "go"
+
+// wordPlaceholder is what gets replaced with the searched word in a
+// declaration pattern.
+const wordPlaceholder = "{{word}}"
+
+// Language defines how to look for declarations in the source files of a
+// programming language.
+type Language struct {
+	// Name is the one word name of the language, as it appears in the UI.
+	Name string
+	// Exts defines the file extension patterns (regexp) of the source files
+	// of the language.
+	Exts []string
+	// Decls maps a declaration kind (e.g. "func", "type") to the pattern
+	// (posix-egrep regexp) that grep uses to find such a declaration. Each
+	// occurrence of {{word}} in the pattern is replaced with the searched
+	// word.
+	Decls map[string]string
+	// IgnoreCase makes the declaration search case insensitive.
+	IgnoreCase bool `json:",omitempty"`
+	// Resolve defines the suffixes that are tried, in order, when opening
+	// the file that an import path refers to. If empty, the language
+	// has no "open" command.
+	Resolve []string `json:",omitempty"`
+}
+
+// builtinLanguages are always known, but they can be overridden by a
+// Language of the same name in the configuration file.
+var builtinLanguages = []Language{
+	{
+		Name: "go",
+		Exts: []string{`\.go`},
+		Decls: map[string]string{
+			"func": `^func[[:space:]]+{{word}}[[:space:]]*[[(]`,
+			"meth": `^func[[:space:]]*\([^)]*\)[[:space:]]*{{word}}[[:space:]]*[[(]`,
+			"type": `^type[[:space:]]+{{word}}([[:space:]]|\[)|^[[:space:]]+{{word}}[[:space:]]+(struct|interface)`,
+			"pkg":  `^package[[:space:]]+{{word}}([[:space:]]|$)`,
+		},
+	},
+	{
+		Name: "python",
+		Exts: []string{`\.py`},
+		Decls: map[string]string{
+			"def":   `^[[:space:]]*(async[[:space:]]+)?def[[:space:]]+{{word}}[[:space:]]*\(`,
+			"class": `^[[:space:]]*class[[:space:]]+{{word}}[[:space:]]*[(:]`,
+		},
+	},
+	{
+		Name: "cpp",
+		Exts: []string{`\.(c|cc|cpp|cxx|h|hh|hpp|hxx)`},
+		Decls: map[string]string{
+			"inc":   `^[[:space:]]*#[[:space:]]*include[[:space:]]*[<"]([^>"]*/)?{{word}}`,
+			"class": `^[[:space:]]*(class|struct)[[:space:]]+{{word}}([[:space:]]|$|:|\{)`,
+			"meth":  `[[:alnum:]_]+::~?{{word}}[[:space:]]*\(`,
+		},
+	},
+	{
+		Name: "fortran",
+		Exts: []string{`\.[fF](90|95|03|08)?`},
+		Decls: map[string]string{
+			"func": `^[[:space:]]*([a-z0-9_*()[:space:]]+[[:space:]])?function[[:space:]]+{{word}}([[:space:]]|\(|$)`,
+			"sub":  `^[[:space:]]*((pure|elemental|recursive)[[:space:]]+)*subroutine[[:space:]]+{{word}}([[:space:]]|\(|$)`,
+			"mod":  `^[[:space:]]*module[[:space:]]+{{word}}([[:space:]]|$)`,
+			"type": `^[[:space:]]*type[[:space:]]*(,[^:]*)?(::)?[[:space:]]*{{word}}([[:space:]]|$)`,
+		},
+		IgnoreCase: true,
+	},
+	{
+		Name:    "js",
+		Exts:    []string{`\.js`, `\.jsx`, `\.mjs`, `\.cjs`},
+		Decls:   jsDecls(),
+		Resolve: []string{"", ".js", ".mjs", ".jsx", "/index.js"},
+	},
+	{
+		Name:    "ts",
+		Exts:    []string{`\.ts`, `\.tsx`},
+		Decls:   tsDecls(),
+		Resolve: []string{"", ".ts", ".tsx", ".d.ts", ".js", "/index.ts", "/index.js"},
+	},
+	{
+		Name:  "proto",
+		Exts:  []string{`\.proto`},
+		Decls: protoDecls(),
+	},
+	{
+		Name:  "asm",
+		Exts:  []string{`\.s`},
+		Decls: asmDecls(),
+	},
+}
+
+// mergeLanguages returns the builtin languages, overridden or completed with
+// the ones from the configuration file.
+func mergeLanguages(loaded []Language) map[string]Language {
+	langs := make(map[string]Language, len(builtinLanguages)+len(loaded))
+	for _, l := range builtinLanguages {
+		langs[l.Name] = l
+	}
+	for _, l := range loaded {
+		langs[l.Name] = l
+	}
+	return langs
+}
+
+// projectLanguages returns the languages, among all, that apply to p, sorted
+// by name. They are the ones listed in p.Languages if any, or otherwise the
+// ones that have an extension in common with p.
+func projectLanguages(p Project, all map[string]Language) []Language {
+	var langs []Language
+	if len(p.Languages) > 0 {
+		for _, name := range p.Languages {
+			if l, ok := all[name]; ok {
+				langs = append(langs, l)
+			}
+		}
+	} else {
+		exts := projectExts(p)
+		for _, l := range all {
+			if sharesExt(l.Exts, exts) {
+				langs = append(langs, l)
+			}
+		}
+	}
+	sort.Slice(langs, func(i, j int) bool { return langs[i].Name < langs[j].Name })
+	return langs
+}
+
+// projectExts returns p.Exts, or the default extensions if p has none.
+func projectExts(p Project) []string {
+	if len(p.Exts) == 0 {
+		return []string{`\.go`}
+	}
+	return p.Exts
+}
+
+// commonExts returns the extensions of a that are also in b.
+func commonExts(a, b []string) []string {
+	var common []string
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				common = append(common, x)
+				break
+			}
+		}
+	}
+	return common
+}
+
+func sharesExt(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commands returns the commands, other than the declaration searches, that
+// are offered on the UI line of l, for a project with the langs languages. If
+// langs is nil, all the commands that l can have are returned.
+func (l Language) commands(langs []Language) []string {
+	var cmds []string
+	if len(l.Resolve) > 0 {
+		cmds = append(cmds, openKeyword)
+	}
+	switch l.Name {
+	case "proto", "asm":
+		cmds = append(cmds, toGoKeyword)
+	case "go":
+		cmds = append(cmds, asmKeyword)
+		if langs == nil || hasLanguage(langs, "proto") {
+			cmds = append(cmds, goToProtoKeyword)
+		}
+	}
+	return cmds
+}
+
+func (l Language) hasCommand(cmd string) bool {
+	for _, v := range l.commands(nil) {
+		if v == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLanguage(langs []Language, name string) bool {
+	for _, l := range langs {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedDecls returns the declaration kinds of l, in alphabetical order.
+func (l Language) sortedDecls() []string {
+	var decls []string
+	for k := range l.Decls {
+		decls = append(decls, k)
+	}
+	sort.Strings(decls)
+	return decls
+}
+
+// declRegex returns the pattern for the decl kind of declaration, for the
+// (already escaped) word.
+func (l Language) declRegex(decl, word string) (string, bool) {
+	tmpl, ok := l.Decls[decl]
+	if !ok {
+		return "", false
+	}
+	return strings.Replace(tmpl, wordPlaceholder, word, -1), true
+}
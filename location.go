@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// UnmarshalJSON accepts a Location, or just its path.
+func (l *Location) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*l = Location{Path: path}
+		return nil
+	}
+	// location has no methods, hence the default decoding.
+	type location Location
+	var loc location
+	if err := json.Unmarshal(data, &loc); err != nil {
+		return err
+	}
+	*l = Location(loc)
+	return nil
+}
+
+// MarshalJSON writes l as just its path, when it has no settings of its own.
+func (l Location) MarshalJSON() ([]byte, error) {
+	if l.Exts == nil && l.Excluded == nil && l.Label == "" && !l.Optional {
+		return marshalIndent(l.Path, "", "")
+	}
+	type location Location
+	return marshalIndent(location(l), "", "")
+}
+
+// name returns what the UI shows for l.
+func (l Location) name() string {
+	if l.Label != "" {
+		return l.Label
+	}
+	return l.Path
+}
+
+// paths returns the paths of the locations of p.
+func (p Project) paths() []string {
+	paths := make([]string, 0, len(p.Locations))
+	for _, l := range p.Locations {
+		paths = append(paths, l.Path)
+	}
+	return paths
+}
+
+// location returns the location of p whose path, or label, is where.
+func (p Project) location(where string) (Location, bool) {
+	for _, l := range p.Locations {
+		if l.Path == where || l.Label != "" && l.Label == where {
+			return l, true
+		}
+	}
+	return Location{}, false
+}
+
+// searchScope is a group of locations that are searched with the same rules.
+type searchScope struct {
+	locations []string
+	exts      []string
+	excluded  []string
+}
+
+// searchScopes returns the locations of p to search, or just the one of where
+// if not empty, grouped by the rules that apply to them: their own Exts and
+// Excluded, or the ones of p otherwise. exts, if not empty, replaces the Exts
+// of all of them, and excluded is added to the Excluded of all of them. Nothing
+// is searched if where is not a location of p, nor in the Optional locations
+// that are missing.
+func searchScopes(p Project, where string, exts, excluded []string) []searchScope {
+	locs := p.Locations
+	if where != "" {
+		l, ok := p.location(where)
+		if !ok {
+			return nil
+		}
+		locs = []Location{l}
+	}
+	var scopes []searchScope
+	byRules := make(map[string]int)
+	for _, l := range locs {
+		if l.Optional {
+			if _, err := os.Stat(l.Path); err != nil {
+				continue
+			}
+		}
+		s := searchScope{exts: projectExts(p), excluded: p.Excluded}
+		if l.Exts != nil {
+			s.exts = l.Exts
+		}
+		if len(exts) > 0 {
+			s.exts = exts
+		}
+		if l.Excluded != nil {
+			s.excluded = l.Excluded
+		}
+		s.excluded = append(append([]string{}, s.excluded...), excluded...)
+		key := strings.Join(s.exts, "\x00") + "\x01" + strings.Join(s.excluded, "\x00")
+		if i, ok := byRules[key]; ok {
+			scopes[i].locations = append(scopes[i].locations, l.Path)
+			continue
+		}
+		byRules[key] = len(scopes)
+		s.locations = []string{l.Path}
+		scopes = append(scopes, s)
+	}
+	return scopes
+}
+
+// scopesPaths returns all the locations of scopes.
+func scopesPaths(scopes []searchScope) []string {
+	var paths []string
+	for _, s := range scopes {
+		paths = append(paths, s.locations...)
+	}
+	return paths
+}
+
+// walkScopes is walkFiles, on the locations of each of scopes, with their
+// excluded patterns.
+func walkScopes(scopes []searchScope, exts []string, fn func(path string) error) error {
+	for _, s := range scopes {
+		if err := walkFiles(s.locations, exts, s.excluded, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,66 +1,10 @@
 // Copyright 2011 Mathieu Lonjaret
 
-// The gofinder program is an acme user interface to search through Go projects.
-// It uses 2-1 chording (see https://swtch.com/plan9port/man/man1/acme.html).
-// It uses a JSON configuration file to define project(s) to search on; see
-// projects-example.json for a working configuration example.
-// It also relies on GNU (not bsd!) grep and find.
-//
-// It displays, in the following order: The name of the project, to perform a
-// global search. The Go Guru (golang.org/x/tools/cmd/guru) modes, to perform a
-// guru search. The project's locations, to perform a local search. For example,
-// with the provided projects-example.json, the UI will look like:
-//
-//	Search in:
-//	-----------------------------------
-//	camlistore:
-//		callees	callers	callstack	definition	describe	freevars	implements	peers	pointsto	referrers	what	whicherrs
-//		/home/mpl/src/camlistore.org	/home/mpl/src/camlistore.org/vendor	/home/mpl/src/go4.org	/home/mpl/src/github.com/mpl
-//	-----------------------------------
-//
-//
-// A brief recap on acme mouse chording: first place the text cursor on the word
-// you want the search to apply to, with a left click at any position on the word.
-// Then send that word as an argument to one of the guru commands with 2-1
-// chording. That means, press and hold the middle click on the command (for example, the
-// "definition" word), and while still holding it, press the left click.
-//
-//
-// The output of commands is printed to the +Errors window.
-//
-//
-// The configuration file is mapped to a project type, which is defined as follows:
-//
-//	type Project struct {
-//		// Name is the one word name describing the project, that will appear at
-//		// the top of the UI. One word, because chording on the name starts a
-//		// global search in the project. Global search means a find on all the
-//		// files ending with the extensions defined in Exts, looking in the
-//		// locations defined in Locations, excluding all the patterns defined in
-//		// Excluded. The results are piped to a grep for the argument that is sent
-//		// with the chord.
-//		Name      string
-//		// Locations defines all the locations relevant to the project, and as
-//		// such, they are displayed on the UI. A global search runs find through
-//		// all of them. A chording on one of the locations will perform a local
-//		// search, i.e. in the same way as a global search, except find will only
-//		// run through that one location.
-//		Locations []string
-//		// Exts defines the file extension patterns (regexp), that find will
-//		// take into account. It defaults to []string{"\.go"} otherwise.
-//		Exts      []string
-//		// Excluded defines the patterns (regexp), that find will take into
-//		// account to exclude from the search results.
-//		Excluded  []string
-//		// GuruScope is the scope that guru will use for the modes that need one.
-//		GuruScope []string
-//	}
 package main
 
 import (
 	"bytes"
 	"encoding/gob"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -83,37 +27,42 @@ const (
 	guruKeyword        = "guru"
 	sourcegraphKeyWord = "sourcegraph"
 	locationKeyword    = "location"
+	declKeyword        = "decl"
+	protoKeyword       = "protomap"
+	tagsLookupKeyword  = "tagslookup"
+	asmMapKeyword      = "asmmap"
 )
 
 const (
 	regex = iota
 	file
-	fortFunc
-	fortMod
-	fortSub
-	fortType
-	cppInc
-	cppClassMeth
-	cppClassMemb
-	goPack
-	goFunc
-	goMeth
-	goTyp
-	pyFunc
+	decl
+	resolve
+	protoMap
+	tagsLookup
+	asmMap
 	doGetProjects
+	doRegister
+	doUnregister
+	doKill
+	doStats
 )
 
 var (
-	port = flag.String("p", "2020", "listening port")
+	port = flag.String("p", "", "listen on this TCP port, on localhost only (on any free one for 0), instead of on a Unix domain socket in $XDG_RUNTIME_DIR, or in the plan9port namespace directory.")
 	help = flag.Bool("h", false, "show this help")
-	// CLI mode flags. disabled for now, until feature is finished.
-	// flagProject = flag.String("project", "", "Name of the project to search in. Defaults to the first one found in the config otherwise.")
-	// flagWhere = flag.Int("where", 0, "Where to search for in the project. Defaults to first location found in the project otherwise.")
-	// flagFunc = flag.String("func", "", "The function to search for.")
-	// flagMethod = flag.String("method", "", "The method to search for.")
-	// flagPkg = flag.String("pkg", "", "The package to search for.")
-	// flagType = flag.String("type", "", "The type to search for.")
-	flagThere = flag.String("there", "", "generate basic config file for repo at the given location, and use it.")
+	// CLI mode flags.
+	flagProject  = flag.String("project", "", "Name of the project to search in. Defaults to the first one (in alphabetical order) found in the config otherwise.")
+	flagWhere    = flag.String("where", "", "The location to search in, within the project. Defaults to all the locations of the project otherwise.")
+	flagFunc     = flag.String("func", "", "The function to search for.")
+	flagMethod   = flag.String("method", "", "The method to search for.")
+	flagPkg      = flag.String("pkg", "", "The package to search for.")
+	flagType     = flag.String("type", "", "The type to search for.")
+	flagRegex    = flag.String("regex", "", "The (posix-egrep) regexp to search for.")
+	flagThere    = flag.String("there", "", "add a basic project for the repo at the given location to the gofind.json of that location, which is created if needed, and use it.")
+	flagDaemon   = flag.Bool("daemon", false, "run without an acme window, only serving the searches to clients. The config is reloaded when it changes, and on SIGHUP.")
+	flagHTTP     = flag.String("http", "", "also serve a web UI on this TCP port, on localhost only.")
+	flagDiscover = flag.String("discover", "", "propose a config file with a project for each Go module, go.work workspace module, and repository found below the given root, and preview it in an acme window, where Put writes it to gofind.json in the root. It is printed on stdout without acme.")
 )
 
 var (
@@ -123,12 +72,20 @@ var (
 	projectWord = regexp.MustCompile(`^[a-zA-Z]+:`)
 	resZone     string
 
-	// Actually guards the whole of findRegex. I wanted to use it as well to
-	// make killFind atomic, but don't think that's whe way to do it.
-	findProcMu sync.Mutex
+	// configWarnings are the warnings about the configuration file that
+	// was loaded, and configProblems what is shown about it at the top of
+	// the window: the errors that prevented it from being loaded, or the
+	// warnings.
+	configWarnings []string
+	configProblems []string
 
-	killGrepMu sync.Mutex
-	killGrep   bool
+	// editableProjects are the names of the projects that have an edit
+	// line: the ones of the configuration file itself.
+	editableProjects map[string]bool
+
+	// searching are the ids of our requests still being served, for Kill.
+	searchingMu sync.Mutex
+	searching   = make(map[uint64]bool)
 
 	findBin = "find"
 	grepBin = "grep"
@@ -172,11 +129,12 @@ func initWindow() {
 		return
 	}
 	w.Name(title)
-	tag := "Reload Kill"
+	tag := "Reload Kill Stats"
 	w.Write("tag", []byte(tag))
 	err = reloadConf(configFile)
 	if err != nil {
-		log.Fatal(err)
+		// it's shown in the window, until Reload fixes it.
+		log.Print(err)
 	}
 }
 
@@ -218,6 +176,12 @@ func printUi() error {
 		return err
 	}
 	w.Write("data", []byte(""))
+	if len(configProblems) > 0 {
+		for _, p := range configProblems {
+			w.Write("body", []byte(p+"\n"))
+		}
+		w.Write("body", []byte("\n"))
+	}
 	w.Write("body", []byte("Search in: \n"))
 	w.Write("body", []byte("-----------------------------------"))
 	w.Write("body", []byte("\n"))
@@ -241,11 +205,32 @@ func printUi() error {
 			}
 		}
 		w.Write("body", []byte("\n"))
+		langs := projectLanguages(v, languages)
+		for _, l := range langs {
+			w.Write("body", []byte("	"+l.Name+":"))
+			for _, d := range l.sortedDecls() {
+				w.Write("body", []byte("	"+d))
+			}
+			for _, c := range l.commands(langs) {
+				w.Write("body", []byte("	"+c))
+			}
+			w.Write("body", []byte("\n"))
+		}
+		if len(v.Tags) > 0 {
+			w.Write("body", []byte("	"+tagsKeyword+":	"+allKinds))
+			for _, k := range tagKinds(v) {
+				w.Write("body", []byte("	"+k))
+			}
+			w.Write("body", []byte("\n"))
+		}
 		if sourcegraphRepo != "" {
 			w.Write("body", []byte("	"+sourcegraphKeyWord+"\n"))
 		}
+		if editableProjects[v.Name] {
+			w.Write("body", []byte("	"+editKeyword+":	"+strings.Join(editCommands, "	")+"\n"))
+		}
 		for _, l := range v.Locations {
-			w.Write("body", []byte("	"+l))
+			w.Write("body", []byte("	"+l.name()))
 		}
 		w.Write("body", []byte("\n"))
 	}
@@ -266,39 +251,125 @@ func printUi() error {
 }
 
 func reloadConf(configFile string) error {
-	err := loadProjects(configFile)
-	if err != nil {
-		return err
+	loadErr := loadProjects(configFile)
+	configProblems = nil
+	if loadErr != nil {
+		configProblems = append(configProblems, strings.Split(loadErr.Error(), "\n")...)
+		configProblems = append(configProblems, "It is loaded again as soon as it changes, or on Reload.")
+		if len(projects) > 0 {
+			configProblems = append(configProblems, "Meanwhile, the previous configuration is still in use.")
+		}
+	} else if len(configWarnings) > 0 {
+		configProblems = append(configProblems, fmt.Sprintf("%d warning(s) in %v:", len(configWarnings), configFile))
+		for _, v := range configWarnings {
+			configProblems = append(configProblems, "\t"+v)
+		}
 	}
-	err = printUi()
+	err := printUi()
 	if err != nil {
 		return err
 	}
-	return nil
+	if loadErr != nil {
+		return loadErr
+	}
+	return registerWindow()
 }
 
 func sendCommand(code int, q *query) {
-	c, err := net.Dial("tcp", "localhost:"+*port)
+	c, err := dialServer()
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return
 	}
 	enc := gob.NewEncoder(c)
-	err = enc.Encode(msg{
+	id := newRequestID()
+	var cancel []uint64
+	if code == doKill {
+		searchingMu.Lock()
+		for v := range searching {
+			cancel = append(cancel, v)
+		}
+		searchingMu.Unlock()
+	}
+	err = enc.Encode(request{
+		Version: protocolVersion,
+		ID:      id,
 		Action:  code,
 		Project: q.project,
 		What:    q.what,
 		Where:   q.where,
+		Lang:    q.lang,
+		Decl:    q.mode,
+		From:    q.from,
+		Client:  clientID,
+		Cancel:  cancel,
 	})
 	if err != nil {
-		log.Fatal("encode error:", err)
+		log.Print("encode error:", err)
+		c.Close()
+		return
 	}
-	defer c.Close()
-	if code != doGetProjects {
+	if code != doGetProjects && code != doKill {
+		// Do not block the event loop while the search runs.
+		searchingMu.Lock()
+		searching[id] = true
+		searchingMu.Unlock()
+		go func() {
+			defer c.Close()
+			defer func() {
+				searchingMu.Lock()
+				delete(searching, id)
+				searchingMu.Unlock()
+			}()
+			done, _, err := readResponses(gob.NewDecoder(c), id)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			if done.Summary != nil && done.Summary.Files > 0 {
+				log.Print(done.Summary)
+			}
+			log.Printf("********")
+		}()
 		return
 	}
+	defer c.Close()
+	if _, _, err := readResponses(gob.NewDecoder(c), id); err != nil {
+		log.Print(err)
+	}
+}
 
-	if err := json.NewDecoder(c).Decode(&projects); err != nil {
-		log.Fatalf("decode error: %v", err)
+// readResponses reads the responses for the request id, until the terminal
+// one, which it returns along with the last error reply, if any. Matches are
+// printed to stdout as they come, errors are logged, and projects are loaded.
+func readResponses(dec *gob.Decoder, id uint64) (done response, lastErr *replyError, err error) {
+	for {
+		var resp response
+		if err := dec.Decode(&resp); err != nil {
+			return done, lastErr, fmt.Errorf("decode error: %v", err)
+		}
+		if resp.ID != id {
+			return done, lastErr, fmt.Errorf("got response for request %d, want %d", resp.ID, id)
+		}
+		switch resp.Kind {
+		case responseError:
+			log.Print(resp.Err)
+			lastErr = resp.Err
+		case responseProjects:
+			projects = resp.Projects
+		case responseRegistered:
+			clientID = resp.Client
+		case responseStats:
+			for _, s := range resp.Stats {
+				fmt.Fprintf(os.Stdout, "%v\n", s)
+			}
+		case responseResults:
+			for _, m := range resp.Matches {
+				fmt.Fprintf(os.Stdout, "%v\n", m)
+			}
+		case responseDone:
+			return resp, lastErr, nil
+		}
 	}
 }
 
@@ -311,38 +382,79 @@ type Project struct {
 	// Excluded. The results are piped to a grep for the argument that is sent
 	// with the chord.
 	Name string
+	// Extends is the name of a project that this one inherits the Locations,
+	// Exts, Excluded, and GuruScope from, when it does not set them. An
+	// element "..." in one of them stands for the ones of that project.
+	Extends string `json:",omitempty"`
 	// Locations defines all the locations relevant to the project, and as
 	// such, they are displayed on the UI. A global search runs find through
 	// all of them. A chording on one of the locations will perform a local
 	// search, i.e. in the same way as a global search, except find will only
-	// run through that one location.
-	Locations []string
-	// Exts defines the file extension patterns (regexp), that find will
+	// run through that one location. Each one is either a path, or a
+	// Location, when it needs its own settings.
+	Locations []Location
+	// Exts defines the file extension patterns (regexp or glob), that find will
 	// take into account. It defaults to []string{"\.go"} otherwise.
 	Exts []string
-	// Excluded defines the patterns (regexp), that find will take into
+	// Excluded defines the patterns (regexp or glob), that find will take into
 	// account to exclude from the search results.
 	Excluded []string `json:"excluded,omitempty"`
 	// GuruScope is the scope that guru will use for the modes that need one.
 	GuruScope []string
+	// Languages lists the names of the languages for which declaration
+	// searches are offered. It defaults to all the languages that have an
+	// extension in common with Exts.
+	Languages []string `json:",omitempty"`
+	// Tags maps a Location to a ctags (tags) or etags (TAGS) file, used to
+	// find declarations in that Location. A relative path is relative to
+	// the Location.
+	Tags map[string]string `json:",omitempty"`
+}
+
+// Location is a location of a project, with the settings that replace the ones
+// of the project for that location.
+type Location struct {
+	Path string
+	// Exts, if not nil, replaces the Exts of the project.
+	Exts []string `json:",omitempty"`
+	// Excluded, if not nil, replaces the Excluded of the project.
+	Excluded []string `json:",omitempty"`
+	// Label, if set, is shown on the UI instead of Path. It is one word,
+	// so that one can chord on it.
+	Label string `json:",omitempty"`
+	// Optional, if set, makes it only a warning for Path not to exist, e.g.
+	// on a mount that is not always there. It is not searched meanwhile.
+	Optional bool `json:",omitempty"`
+}
+
+// config is the layout of the configuration file. For compatibility, the
+// file can also simply be a JSON array of projects.
+type config struct {
+	// Include lists other configuration files, whose languages and projects
+	// are added to the ones of this file. A relative path is relative to the
+	// directory of this file.
+	Include []string `json:",omitempty"`
+	// Languages defines new languages, or overrides the builtin ones.
+	Languages []Language `json:",omitempty"`
+	Projects  []Project
+
+	// the JSON paths of the languages, and projects, for the errors.
+	languagePaths []string
+	projectPaths  []string
+	// the absolute paths of the files that define the projects.
+	projectFiles []string
 }
 
 func loadProjects(file string) error {
-	var loaded []Project
-	r, err := os.Open(file)
-	if err != nil {
-		return err
-	}
-	defer r.Close()
-	dec := json.NewDecoder(r)
-	err = dec.Decode(&loaded)
+	cs, err := readConfig(file)
 	if err != nil {
 		return err
 	}
-	projects = make(map[string]Project, 1)
-	for _, v := range loaded {
-		projects[v.Name] = v
-	}
+	projects, languages, configWarnings = cs.projects, cs.languages, cs.warnings
+	editableProjects = cs.editable
+	// The server sees the new configuration at once, while the UI globals
+	// above are only ever used from the event loop.
+	currentConfig.Store(cs)
 	return nil
 }
 
@@ -353,6 +465,7 @@ func escapeSpecials(s string) string {
 	escaped = strings.Replace(escaped, `+`, `\+`, -1)
 	escaped = strings.Replace(escaped, `?`, `\?`, -1)
 	escaped = strings.Replace(escaped, `.`, `\.`, -1)
+	escaped = strings.Replace(escaped, `$`, `\$`, -1)
 	return escaped
 }
 
@@ -371,6 +484,13 @@ func dispatchSearch(q *query) {
 		return
 	}
 
+	if kind == editKeyword {
+		if err := editProject(q); err != nil {
+			log.Printf("%v error: %v", q.mode, err)
+		}
+		return
+	}
+
 	if kind == guruKeyword {
 		// TODO(mpl): move the guru call to the "server"? Not really a win,
 		// but just out of consistency.
@@ -380,6 +500,38 @@ func dispatchSearch(q *query) {
 		return
 	}
 
+	if kind == tagsLookupKeyword {
+		sendCommand(tagsLookup, q)
+		return
+	}
+
+	if kind == declKeyword || kind == openKeyword || kind == protoKeyword || kind == asmMapKeyword {
+		l, ok := languages[q.lang]
+		if !ok {
+			log.Printf("%s is not a known language\n", q.lang)
+			return
+		}
+		if kind == openKeyword {
+			sendCommand(resolve, q)
+			return
+		}
+		if kind == protoKeyword {
+			sendCommand(protoMap, q)
+			return
+		}
+		if kind == asmMapKeyword {
+			sendCommand(asmMap, q)
+			return
+		}
+		if _, ok := l.Decls[q.mode]; !ok {
+			log.Printf("%s is not a declaration kind for %s\n", q.mode, q.lang)
+			return
+		}
+		q.what = escapeSpecials(what)
+		sendCommand(decl, q)
+		return
+	}
+
 	if kind == sourcegraphKeyWord {
 		if err := sourcegraph(q.what); err != nil {
 			log.Printf("sourcegraph error: %v", err)
@@ -392,15 +544,8 @@ func dispatchSearch(q *query) {
 		return
 	}
 	if where != "" {
-		found := false
-		// search only in a specific location (path)
-		for _, l := range v.Locations {
-			if l == where {
-				found = true
-				break
-			}
-		}
-		if found == false {
+		// search only in a specific location (path, or label)
+		if _, ok := v.location(where); !ok {
 			log.Printf("%s is not a location of %s project\n", where, proj)
 			return
 		}
@@ -411,8 +556,10 @@ func dispatchSearch(q *query) {
 
 type query struct {
 	project string
-	kind    string // "location" for location search, or "guru", or "everywhere".
-	mode    string // the guru mode if kind is "guru".
+	kind    string // "location" for location search, or "guru", or "decl", or "everywhere".
+	mode    string // the guru mode if kind is "guru", the declaration kind if kind is "decl" or "tagslookup".
+	lang    string // the language if kind is "decl", "open", "protomap", or "asmmap".
+	from    string // the location of the argument, if kind is "open".
 	where   string
 	what    string
 }
@@ -454,7 +601,31 @@ func buildQuery(e acme.Event) (*query, error) {
 
 	target := string(e.Text)
 	q := new(query)
-	if _, ok := guruModes[target]; ok {
+	if fields := strings.Fields(string(line[:n])); len(fields) > 0 && fields[0] == tagsKeyword+":" {
+		q.kind = tagsLookupKeyword
+		q.mode = target
+	} else if len(fields) > 0 && fields[0] == editKeyword+":" {
+		if !isEditCommand(target) {
+			return nil, errors.New("wrong click")
+		}
+		q.kind = editKeyword
+		q.mode = target
+	} else if lang, ok := langOfLine(string(line[:n])); ok {
+		if target == openKeyword && lang.hasCommand(target) {
+			q.kind = openKeyword
+			q.from = locFile(string(e.Loc))
+		} else if (target == toGoKeyword && lang.Name == "proto" || target == goToProtoKeyword) && lang.hasCommand(target) {
+			q.kind = protoKeyword
+		} else if (target == toGoKeyword && lang.Name == "asm" || target == asmKeyword) && lang.hasCommand(target) {
+			q.kind = asmMapKeyword
+		} else if _, ok := lang.Decls[target]; ok {
+			q.kind = declKeyword
+			q.mode = target
+		} else {
+			return nil, errors.New("wrong click")
+		}
+		q.lang = lang.Name
+	} else if _, ok := guruModes[target]; ok {
 		q.kind = guruKeyword
 		q.mode = target
 		q.where = string(e.Loc)
@@ -489,8 +660,40 @@ func buildQuery(e acme.Event) (*query, error) {
 	return nil, errors.New("invalid search kind")
 }
 
+// langOfLine returns the language whose declaration kinds are listed on line,
+// if any.
+func langOfLine(line string) (Language, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasSuffix(fields[0], ":") {
+		return Language{}, false
+	}
+	l, ok := languages[strings.TrimSuffix(fields[0], ":")]
+	return l, ok
+}
+
 func eventLoop(c chan int) {
-	for e := range w.EventChan() {
+	changed := make(chan bool, 1)
+	go watchConfigs(changed)
+	events := w.EventChan()
+loop:
+	for {
+		var e *acme.Event
+		select {
+		case <-changed:
+			// On error, the previous configuration stays in use, and
+			// the window says why.
+			if err := reloadConf(configFile); err != nil {
+				log.Print(err)
+				continue
+			}
+			log.Printf("reloaded %v", configFile)
+			continue
+		case ev, ok := <-events:
+			if !ok {
+				break loop
+			}
+			e = ev
+		}
 		switch e.C2 {
 		case 'x': // execute in tag
 			switch string(e.Text) {
@@ -502,9 +705,10 @@ func eventLoop(c chan int) {
 					log.Print(err)
 				}
 			case "Kill":
-				killGrepMu.Lock()
-				killGrep = true
-				killGrepMu.Unlock()
+				// The server might be another gofinder's.
+				sendCommand(doKill, &query{})
+			case "Stats":
+				sendCommand(doStats, &query{})
 			default:
 				w.WriteEvent(e)
 			}
@@ -528,22 +732,33 @@ func eventLoop(c chan int) {
 }
 
 func guru(mode, loc, scope string) error {
+	args, out, err := runGuru(mode, loc, scope)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, "********\n")
+	fmt.Fprintf(os.Stdout, "%s", string(out))
+	fmt.Fprint(os.Stdout, "********\n")
+	w.Write("body", []byte("guru "+strings.Join(args, " ")+"\n"))
+	return nil
+}
+
+// runGuru runs guru in mode, at the position loc, with the guru scope of the
+// project scope for the modes that need one. It returns the guru arguments,
+// and output.
+func runGuru(mode, loc, scope string) ([]string, []byte, error) {
 	args := []string{mode, loc}
 	if needsScope, _ := guruModes[mode]; needsScope {
-		args = []string{"-scope", strings.Join(projects[scope].GuruScope, ","), mode, loc}
+		args = []string{"-scope", strings.Join(configOf(nil).projects[scope].GuruScope, ","), mode, loc}
 	}
 	cmd := exec.Command("guru", args...)
 	var stderr, stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%v; %v; %v; %v", err, strings.Join(args, " "), string(stderr.Bytes()), string(stdout.Bytes()))
+		return nil, nil, fmt.Errorf("%v; %v; %v; %v", err, strings.Join(args, " "), string(stderr.Bytes()), string(stdout.Bytes()))
 	}
-	fmt.Fprint(os.Stdout, "********\n")
-	fmt.Fprintf(os.Stdout, "%s", string(stdout.Bytes()))
-	fmt.Fprint(os.Stdout, "********\n")
-	w.Write("body", []byte("guru "+strings.Join(args, " ")+"\n"))
-	return nil
+	return args, stdout.Bytes(), nil
 }
 
 func sourcegraph(what string) error {
@@ -575,8 +790,10 @@ func sourcegraph(what string) error {
 	return nil
 }
 
-// TODO(mpl): use go generate to write that doc as the program doc too?
-
+// docTxt is the only source of the program doc, in doc.go, and of README.txt,
+// which gendoc.go writes from it.
+//
+//go:generate go run gendoc.go
 var docTxt = `
 The gofinder program is an acme user interface to search through Go projects.
 It uses 2-1 chording (see https://swtch.com/plan9port/man/man1/acme.html).
@@ -593,6 +810,7 @@ with the provided projects-example.json, the UI will look like:
 	-----------------------------------
 	camlistore:
 		callees	callers	callstack	definition	describe	freevars	implements	peers	pointsto	referrers	what	whicherrs
+		go:	func	meth	pkg	type	asm
 		/home/mpl/src/camlistore.org	/home/mpl/src/camlistore.org/vendor	/home/mpl/src/go4.org	/home/mpl/src/github.com/mpl
 	-----------------------------------
 
@@ -606,6 +824,40 @@ chording. That means, press and hold the middle click on the command (for exampl
 
 The output of commands is printed to the +Errors window.
 
+Each search ends with its metrics: the number of matches, of files walked, of
+files filtered out by Exts or Excluded, of bytes read, and how long walking and
+matching took. The Stats command, in the tag of the window, prints the totals
+for each project (of each configuration file) since the server started, along
+with its slowest search.
+
+With -daemon, gofinder runs without an acme window, and only serves the
+searches of other clients, over a Unix domain socket (or over localhost TCP,
+with -p). The configuration file is then also reloaded on SIGHUP.
+
+Several gofinder windows, even with different configuration files, share one
+server: the first gofinder started serves the searches of the ones started
+after it, which register their configuration file and window with it, so that
+each window gets its own projects and history. That server keeps running after
+its own window is deleted, for as long as the other windows are there.
+
+A server writes a discovery file, with its address and PID, for each
+configuration file it serves, in the gofinder.d directory next to the socket.
+The clients look there first for the server of their configuration file, which
+is how they find a server that listens on any free port, with -p 0. The
+discovery files of the servers that are gone are removed.
+
+With -http port, gofinder also serves a web UI on localhost:port, for the ones
+who do not use acme. It lists the projects and their locations, and offers the
+same searches as the acme UI. The results are shown as they come, and link to a
+view of the source file, with the matching line highlighted. Only the files in
+the locations of the projects can be viewed.
+
+With one of the -func, -method, -pkg, -type, or -regex flags, gofinder is a
+command-line client instead: it sends the search to the running server (or
+runs it by itself, with the given configuration file, if there is none), and
+prints the results on stdout, in the same format as grep -n. As with grep, the
+exit code is 0 if there were matches, 1 if there were none, and 2 on error.
+
 
 The configuration file is mapped to a project type, which is defined as follows:
 
@@ -618,21 +870,149 @@ The configuration file is mapped to a project type, which is defined as follows:
 		// Excluded. The results are piped to a grep for the argument that is sent
 		// with the chord.
 		Name      string
+		// Extends is the name of a project that this one inherits the Locations,
+		// Exts, Excluded, and GuruScope from, when it does not set them. An
+		// element "..." in one of them stands for the ones of that project.
+		Extends   string
 		// Locations defines all the locations relevant to the project, and as
 		// such, they are displayed on the UI. A global search runs find through
 		// all of them. A chording on one of the locations will perform a local
 		// search, i.e. in the same way as a global search, except find will only
 		// run through that one location.
-		Locations []string
-		// Exts defines the file extension patterns (regexp), that find will
+		Locations []Location
+		// Exts defines the file extension patterns (regexp or glob), that find will
 		// take into account. It defaults to []string{"\.go"} otherwise.
 		Exts      []string
-		// Excluded defines the patterns (regexp), that find will take into
+		// Excluded defines the patterns (regexp or glob), that find will take into
 		// account to exclude from the search results.
 		Excluded  []string
 		// GuruScope is the scope that guru will use for the modes that need one.
 		GuruScope []string
+		// Languages lists the names of the languages for which declaration
+		// searches are offered. It defaults to all the languages that have an
+		// extension in common with Exts.
+		Languages []string
+		// Tags maps a Location to a ctags (tags) or etags (TAGS) file, used to
+		// find declarations in that Location. A relative path is relative to
+		// the Location.
+		Tags map[string]string
 	}
+
+Each project also has a line per language, listing the kinds of declarations
+(func, type, etc) that can be searched for in that language. The builtin
+languages are go, python, cpp, fortran, js, ts, proto, and asm. Other languages
+can be defined, or the builtin ones overridden, in the configuration file. It
+is then an object with a Languages and a Projects field, instead of an array of
+projects:
+
+	{"Languages": [{"Name": "rust", "Exts": ["\\.rs"],
+		"Decls": {"fn": "^[[:space:]]*(pub[[:space:]]+)?fn[[:space:]]+{{word}}[[:space:]<(]"}}],
+	"Projects": [...]}
+
+where each occurrence of {{word}} in a declaration pattern is replaced with the
+searched word.
+
+Languages with import paths, like js and ts, also have an open command on their
+line, to open the file that the chorded import path refers to.
+
+The proto line has a go command, that finds the Go code generated for a message,
+enum, service, or rpc, according to the go_package option and to the
+protoc-gen-go naming rules. Conversely, when the project has the proto language,
+the go line has a proto command, that finds the proto definition of a generated
+Go type or method.
+
+Similarly, the asm command on the go line finds the Go functions declared
+without a body, along with their assembly (TEXT symbol) implementations for each
+GOARCH. When the project has the asm language, the go command on the asm line
+finds the Go prototype of a TEXT symbol.
+
+A project with Tags has a tags line, which lists the all command, followed by
+all the kinds of tags found in its tags files. Chording on one of them looks up
+the tags of that kind (or of any kind, for all) named after the argument. The
+tags files are loaded again for the lookups whenever they change, but the tags
+line only lists the kinds found when the window was last drawn, e.g. on Reload.
+
+A Location is either just a path, or an object with a Path, and optionally its
+own Exts and Excluded, which replace the ones of the project for the searches
+in that Location, and a one word Label, shown on the UI instead of the path:
+
+	"Locations": ["/home/me/src/foo",
+		{"Path": "/home/me/src/bar/web", "Exts": ["\\.js", "\\.css"], "Label": "web"}]
+
+A Location with "Optional": true, e.g. on a mount that is not always there, may
+not exist: it is then only a warning, and it is not searched until it is back.
+
+The Exts and Excluded patterns can also be globs, prefixed with "glob:", in the
+gitignore style: they are matched against the path relative to the Location, a
+glob without a slash matches a name at any depth, ** matches any number of
+directories, and a glob that matches a directory also matches all its files.
+E.g. "glob:*.pb.go", or "glob:**/third_party/closure/**".
+
+In the Locations, the GuruScope, and the Tags, the environment variables ($VAR
+or ${VAR}) are expanded, and so is a leading ~ in the paths. $GOPATH (its first
+directory) and $GOMODCACHE default to what the go command uses, and any other
+unset variable is an error. A relative Location is relative to the directory of
+the configuration file, so that the same file can be shared, e.g. by checking
+it in, along with the code.
+
+The configuration file can include other ones, whose languages and projects are
+added to its own, with an Include field, and a project can extend another one,
+e.g. to share the Excluded patterns:
+
+	{"Include": ["shared/go4.json"],
+	"Projects": [{"Name": "perkeep", "Extends": "go4",
+		"Locations": ["...", "$GOPATH/src/perkeep.org"],
+		"Excluded": ["...", "glob:**/third_party/closure/**"]}]}
+
+where perkeep searches the Locations of go4, and its own, and it also uses the
+Exts of go4. Include cycles, Extends cycles, and unknown projects in Extends
+are errors. The included files are not watched: they are read again along with
+the configuration file, e.g. on Reload.
+
+Without a configuration file argument, gofinder uses the gofind.json (as
+written by -there) of the current directory, or of the nearest of its parents.
+The user configuration file, $XDG_CONFIG_HOME/gofinder/config.json (or
+~/.config/gofinder/config.json), if it exists, is loaded along with any
+configuration file, and is used alone if there is no gofind.json. It is for
+the projects that should always be there, like the standard library, and for
+the projects that others extend. A project of the configuration file replaces
+the project of the user configuration file with the same name.
+
+With -discover root, gofinder proposes a gofind.json for root, with a project
+for each Go module, each module used by a go.work workspace, and each
+repository without any module, found below root. The Exts are the ones of the
+builtin languages whose files are there, the GuruScope lists the main packages,
+and the modules nested in a module are excluded from its project. The proposal
+is shown in an acme window, for review, where Put writes it to the gofind.json
+of root. Without acme, it is printed on stdout.
+
+With -there location, gofinder adds a project for location to the gofind.json of
+location, and creates it if there is none. The gofind.json files of the parents
+of location are left alone. When a project already has that name or location,
+the location is only added to that project, if needed. Everything else in the
+file is kept, in the same order, and the previous version of the file is saved
+as gofind.json.bak.
+
+The edit line of a project window changes the project in the configuration file.
+Chord AddLoc with a directory to add it to the Locations, Exclude with a regexp
+or a glob to add it to the Excluded, and DelLoc with a location, or its label,
+to remove it from the Locations. The rest of the file, and its formatting, is
+kept, and the window is redrawn once the configuration is loaded again. Only the
+projects of the configuration file itself can be edited, and have an edit line,
+not the ones of the files it includes, or of the user configuration file.
+
+The configuration file is checked when it is loaded, and on Reload: the
+patterns must be valid regexps, the Locations must be existing directories, and
+the Names must be unique, and one word of letters only. The errors, along with
+their JSON path (e.g. Projects[1].Exts[0]), are shown at the top of the window,
+as are the warnings, e.g. about a Location within another one, an Optional one
+that does not exist, or an unknown field, which is most likely a typo (fields
+of one's own, e.g. an Owner, are otherwise ignored).
+
+The configuration file is watched (with inotify on Linux, and by polling it
+otherwise), and loaded again as soon as it changes, after which the window is
+redrawn. If the new configuration has errors, the previous one stays in use,
+and the errors are shown at the top of the window until they are fixed.
 `
 
 func guessRepo(configFile string) (string, error) {
@@ -665,33 +1045,59 @@ func genConfig(there string) (string, error) {
 	}
 	location := absThere
 	name := filepath.Base(location)
+	// Only the one of location, and not the one of a parent, which would
+	// be a surprise.
+	configFile := filepath.Join(location, repoConfigName)
+	_, err = os.Stat(configFile)
+	exists := err == nil
 	// TODO(mpl): maybe add all dirs in location to be guruScopes? or something
 	// smarter than the current state anyway.
 	sourcegraphRepo = strings.TrimPrefix(location, filepath.Join(gopath, "src")+string(filepath.Separator))
 	guruScope := filepath.Join(sourcegraphRepo, "TOBEREPLACED")
 	project := Project{
 		Name:      name,
-		Locations: []string{location},
+		Locations: []Location{{Path: relativePath(filepath.Dir(configFile), location)}},
 		Exts:      []string{`\.go`, `\.js`},
 		GuruScope: []string{guruScope},
 	}
-	projects := []Project{project}
-	configFile := filepath.Join(location, "gofind.json")
-	if _, err := os.Stat(configFile); err == nil {
-		return "", fmt.Errorf("refusing to overwrite existing config file at %v", configFile)
+	if !exists {
+		data, err := marshalIndent([]Project{project}, "", "	")
+		if err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(configFile, data, 0600); err != nil {
+			return "", err
+		}
+		return configFile, nil
+	}
+	old, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return "", err
 	}
-	data, err := json.MarshalIndent(projects, "", "	")
+	data, changed, err := mergeProject(old, filepath.Dir(configFile), project)
 	if err != nil {
+		return "", fmt.Errorf("could not add %v to %v: %v", location, configFile, err)
+	}
+	if !changed {
+		return configFile, nil
+	}
+	fi, err := os.Stat(configFile)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(configFile+".bak", old, fi.Mode().Perm()); err != nil {
 		return "", err
 	}
-	if err := ioutil.WriteFile(configFile, data, 0600); err != nil {
+	if err := writeFileAtomic(configFile, data, fi.Mode().Perm()); err != nil {
 		return "", err
 	}
+	log.Printf("added %v to %v, whose previous version is in %v", location, configFile, configFile+".bak")
 	return configFile, nil
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: gofind projects.json \n")
+	fmt.Fprintf(os.Stderr, "Usage: gofind [projects.json]\n")
+	fmt.Fprintf(os.Stderr, "       gofind [-project name] [-where location] -func|-method|-pkg|-type|-regex what [projects.json]\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, docTxt)
 	os.Exit(2)
@@ -705,12 +1111,23 @@ func main() {
 	}
 	args := flag.Args()
 
+	if isCLI() {
+		os.Exit(runCLI(args))
+	}
+
+	if *flagDiscover != "" {
+		if err := discover(*flagDiscover); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if *flagThere != "" && flag.NArg() == 1 {
 		fmt.Fprintf(os.Stderr, "config file argument and -there flag are mutually exclusive")
 		usage()
 	}
 
-	if *flagThere == "" && flag.NArg() != 1 {
+	if flag.NArg() > 1 {
 		usage()
 	}
 
@@ -721,7 +1138,14 @@ func main() {
 			log.Fatal(err)
 		}
 	} else {
-		configFile = args[0]
+		if len(args) == 1 {
+			configFile = args[0]
+		} else {
+			var err error
+			if configFile, err = defaultConfigFile(); err != nil {
+				log.Fatal(err)
+			}
+		}
 		repo, err := guessRepo(configFile)
 		if err != nil {
 			log.Printf("Could not guess our repo for Sourcegraph queries: %v", err)
@@ -730,19 +1154,49 @@ func main() {
 		}
 	}
 
-	// TODO(mpl): restore CLI mode when ready.
-	initWindow()
+	if *flagHTTP != "" {
+		go func() {
+			log.Printf("web UI error: %v", serveHTTP(*flagHTTP))
+		}()
+	}
+
+	if *flagDaemon {
+		log.Fatal(runDaemon())
+	}
+
 	c := make(chan int)
-	//TODO: window should not start if can't listen
-	go listen(c)
+	var ln net.Listener
+	if conn, err := dialDiscovered(configFile); err == nil {
+		conn.Close()
+		log.Printf("using the server already running for %v", configFile)
+	} else if ln, err = newListener(); err != nil {
+		// Share the server of another gofinder, if there's one.
+		conn, dialErr := dialServer()
+		if dialErr != nil {
+			log.Fatal(err)
+		}
+		conn.Close()
+		ln = nil
+		log.Printf("using the already running server")
+	} else {
+		setListener(ln)
+		go listen(ln, c)
+	}
+	initWindow()
 	go eventLoop(c)
 	<-c
+	unregisterWindow()
 	w.Ctl("delete")
 	w.CloseFiles()
+	if ln != nil {
+		lingerForClients()
+		unadvertiseAll()
+	}
 	// with an acme ui it's actually not necessary anymore  to have
 	// a listening server, however I'm keeping it that way because:
 	// 1) it's probably not big of a slowdown to send the requests to a server
 	// wrt to the searches themselves
 	// 2) it makes for a nice example of using gobs
+	// 3) other clients can share it, and it can run without acme with -daemon
 
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+)
+
+// marshalIndent is json.MarshalIndent, but for the escaping of <, >, and &,
+// which have no business in a configuration file.
+func marshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(prefix, indent)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// mergeProject adds p to the configuration file data, which is in dir, or
+// updates the project of data that has the same name as p, or one of its
+// locations: that project gets the locations of p it does not have yet.
+// Everything else in data is kept as it is. It returns the new contents of
+// the file, and whether they changed.
+func mergeProject(data []byte, dir string, p Project) ([]byte, bool, error) {
+	list := jsonSpan{start: 0, end: len(data)}
+	top := jsonSpan{start: len(data) - len(bytes.TrimLeft(data, " \t\r\n")), end: len(data)}
+	var topFields []jsonSpan
+	hasList := true
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '[' {
+		var err error
+		if topFields, err = jsonChildren(data, top.start, top.end); err != nil {
+			return nil, false, err
+		}
+		list, hasList = field(topFields, "Projects")
+	}
+	var projects []jsonSpan
+	if hasList {
+		var err error
+		if projects, err = jsonChildren(data, list.start, list.end); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, ps := range projects {
+		fields, err := jsonChildren(data, ps.start, ps.end)
+		if err != nil {
+			return nil, false, err
+		}
+		var name string
+		if f, ok := field(fields, "Name"); ok {
+			if err := json.Unmarshal(data[f.start:f.end], &name); err != nil {
+				return nil, false, err
+			}
+		}
+		var raws []json.RawMessage
+		var locs []Location
+		if f, ok := field(fields, "Locations"); ok {
+			if err := json.Unmarshal(data[f.start:f.end], &raws); err != nil {
+				return nil, false, err
+			}
+			if err := json.Unmarshal(data[f.start:f.end], &locs); err != nil {
+				return nil, false, err
+			}
+		}
+		var missing []Location
+		for _, l := range p.Locations {
+			if !hasLocation(locs, l, dir) {
+				missing = append(missing, l)
+			}
+		}
+		if name != p.Name && len(missing) == len(p.Locations) {
+			continue
+		}
+		if len(missing) == 0 {
+			return data, false, nil
+		}
+		if _, ok := field(fields, "Extends"); ok && len(locs) == 0 {
+			// keep on inheriting them.
+			raws = []json.RawMessage{json.RawMessage(`"` + inheritMarker + `"`)}
+		}
+		for _, l := range missing {
+			v, err := marshalIndent(l, "", "")
+			if err != nil {
+				return nil, false, err
+			}
+			raws = append(raws, v)
+		}
+		return setList(data, ps, fields, "Locations", raws), true, nil
+	}
+
+	// A new project, indented like the last one.
+	indent, unit := "", "\t"
+	if n := len(projects); n > 0 {
+		last := projects[n-1]
+		indent = lineIndent(data, last.start)
+		if fields, err := jsonChildren(data, last.start, last.end); err == nil {
+			unit = indentUnit(data, last, fields, unit)
+		}
+	} else if hasList {
+		indent = lineIndent(data, list.start)
+	}
+	value, err := marshalIndent(p, indent, unit)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hasList {
+		value = append([]byte(`"Projects": [`), append(value, ']')...)
+		return insertChild(data, top, topFields, value), true, nil
+	}
+	return insertChild(data, list, projects, value), true, nil
+}
+
+// hasLocation reports whether locs, of a configuration file in dir, has the
+// path of l, once expanded.
+func hasLocation(locs []Location, l Location, dir string) bool {
+	want, err := expandPath(l.Path, dir)
+	if err != nil {
+		return false
+	}
+	for _, v := range locs {
+		if got, err := expandPath(v.Path, dir); err == nil && filepath.Clean(got) == filepath.Clean(want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// goToProtoKeyword is the command, on the go line of the UI, that finds the
+// proto definition of a generated Go type.
+const goToProtoKeyword = "proto"
+
+func protoDecls() map[string]string {
+	return map[string]string{
+		"message": `^[[:space:]]*message[[:space:]]+{{word}}([[:space:]]|\{|$)`,
+		"enum":    `^[[:space:]]*enum[[:space:]]+{{word}}([[:space:]]|\{|$)`,
+		"service": `^[[:space:]]*service[[:space:]]+{{word}}([[:space:]]|\{|$)`,
+		"rpc":     `^[[:space:]]*rpc[[:space:]]+{{word}}[[:space:]]*\(`,
+	}
+}
+
+// protoSymbol is a definition in a .proto file.
+type protoSymbol struct {
+	kind string   // message, enum, service, or rpc
+	path []string // names of the enclosing definitions, then of the symbol itself
+	line int
+}
+
+// name returns the name of the symbol, relative to the proto package.
+func (s protoSymbol) name() string {
+	return strings.Join(s.path, ".")
+}
+
+// goNames returns the names of the Go types (or methods, for an rpc) that
+// protoc-gen-go and protoc-gen-go-grpc generate for the symbol.
+func (s protoSymbol) goNames() []string {
+	switch s.kind {
+	case "message", "enum":
+		return []string{goCamelCase(s.name())}
+	case "service":
+		name := goCamelCase(s.path[len(s.path)-1])
+		return []string{name + "Client", name + "Server"}
+	case "rpc":
+		return []string{goCamelCase(s.path[len(s.path)-1])}
+	}
+	return nil
+}
+
+// protoFile is what we know about a .proto file.
+type protoFile struct {
+	name string
+	// goPackage is the import path from the go_package option, if any.
+	goPackage string
+	symbols   []protoSymbol
+}
+
+type protoToken struct {
+	text     string
+	line     int
+	isString bool
+}
+
+// tokenizeProto splits the proto source src into tokens, skipping comments
+// and whitespace. Strings are returned unquoted.
+func tokenizeProto(src []byte) []protoToken {
+	var toks []protoToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			i += 2
+			for i < len(src) && !(src[i] == '*' && i+1 < len(src) && src[i+1] == '/') {
+				if src[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += 2
+		case c == '"' || c == '\'':
+			start := i + 1
+			i++
+			for i < len(src) && src[i] != c && src[i] != '\n' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			end := i
+			if end > len(src) {
+				end = len(src)
+			}
+			toks = append(toks, protoToken{text: string(src[start:end]), line: line, isString: true})
+			i++
+		case isProtoIdentByte(c):
+			start := i
+			for i < len(src) && (isProtoIdentByte(src[i]) || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, protoToken{text: string(src[start:i]), line: line})
+		default:
+			toks = append(toks, protoToken{text: string(c), line: line})
+			i++
+		}
+	}
+	return toks
+}
+
+func isProtoIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// parseProto returns the definitions found in the .proto file name.
+func parseProto(name string) (*protoFile, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pf := &protoFile{name: name}
+	toks := tokenizeProto(src)
+	// stack holds the names of the enclosing blocks, "" for the anonymous
+	// ones (oneof, option values, rpc bodies...).
+	var stack []string
+	// enclosing returns the names of the named enclosing blocks.
+	enclosing := func() []string {
+		var path []string
+		for _, v := range stack {
+			if v != "" {
+				path = append(path, v)
+			}
+		}
+		return path
+	}
+	next := ""
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.isString {
+			continue
+		}
+		switch t.text {
+		case "message", "enum", "service":
+			if i+2 < len(toks) && !toks[i+1].isString && toks[i+2].text == "{" {
+				name := toks[i+1].text
+				pf.symbols = append(pf.symbols, protoSymbol{
+					kind: t.text,
+					path: append(enclosing(), name),
+					line: t.line,
+				})
+				next = name
+				i++
+			}
+		case "rpc":
+			if i+1 < len(toks) && !toks[i+1].isString {
+				pf.symbols = append(pf.symbols, protoSymbol{
+					kind: t.text,
+					path: append(enclosing(), toks[i+1].text),
+					line: t.line,
+				})
+				i++
+			}
+		case "option":
+			if i+3 < len(toks) && toks[i+1].text == "go_package" && toks[i+2].text == "=" && toks[i+3].isString {
+				pf.goPackage = strings.SplitN(toks[i+3].text, ";", 2)[0]
+				i += 3
+			}
+		case "{":
+			stack = append(stack, next)
+			next = ""
+		case "}":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return pf, nil
+}
+
+// goCamelCase returns the Go name for the proto name s, following the
+// protoc-gen-go rules.
+func goCamelCase(s string) string {
+	isLower := func(c byte) bool { return 'a' <= c && c <= 'z' }
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.' && i+1 < len(s) && isLower(s[i+1]):
+			// Skip over '.' in ".{{lowercase}}".
+		case c == '.':
+			b = append(b, '_')
+		case c == '_' && (i == 0 || s[i-1] == '.'):
+			// Make sure we start with a capital letter.
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && isLower(s[i+1]):
+			// Skip over '_' in "_{{lowercase}}".
+		case '0' <= c && c <= '9':
+			b = append(b, c)
+		default:
+			if isLower(c) {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && isLower(s[i+1]); i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
+
+// protoFiles parses all the .proto files below locations.
+func protoFiles(scopes []searchScope) ([]*protoFile, error) {
+	var pfs []*protoFile
+	err := walkScopes(scopes, []string{`\.proto`}, func(path string) error {
+		pf, err := parseProto(path)
+		if err != nil {
+			// it vanished, or we can't read it. move on.
+			return nil
+		}
+		pfs = append(pfs, pf)
+		return nil
+	})
+	return pfs, err
+}
+
+// protoToGo sends to r the locations, in the generated Go code below
+// locations, of the Go declarations that correspond to the proto definitions
+// named what.
+func protoToGo(r *replier, what string, scopes []searchScope) error {
+	pfs, err := protoFiles(scopes)
+	if err != nil {
+		return err
+	}
+	// goPackage -> Go names to look for in that package
+	wanted := make(map[string][]string)
+	for _, pf := range pfs {
+		for _, s := range pf.symbols {
+			if s.path[len(s.path)-1] != what && s.name() != what {
+				continue
+			}
+			wanted[pf.goPackage] = append(wanted[pf.goPackage], s.goNames()...)
+		}
+	}
+	if len(wanted) == 0 {
+		return fmt.Errorf("no proto definition for %s", what)
+	}
+	for goPackage, names := range wanted {
+		rx := regexp.MustCompile(`^(type[[:space:]]+(` + strings.Join(names, "|") + `)[[:space:]]|[[:space:]]+(` + strings.Join(names, "|") + `)\()`)
+		n, err := grepGoPackage(r, rx, goPackage, scopes)
+		if err != nil {
+			return err
+		}
+		if n == 0 && goPackage != "" {
+			// go_package might not match the directory layout, e.g. with
+			// modules outside of GOPATH, so try everywhere.
+			if _, err := grepGoPackage(r, rx, "", scopes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// grepGoPackage sends to r the lines matching rx in the generated Go files
+// below locations, that are in a directory whose path ends with goPackage, if
+// not empty. It returns the number of matching lines.
+func grepGoPackage(r *replier, rx *regexp.Regexp, goPackage string, scopes []searchScope) (int, error) {
+	n := 0
+	err := walkScopes(scopes, []string{`\.pb\.go`}, func(path string) error {
+		dir := filepath.ToSlash(filepath.Dir(path))
+		if goPackage != "" && dir != goPackage && !strings.HasSuffix(dir, "/"+goPackage) {
+			return nil
+		}
+		matches, err := grepFile(path, rx)
+		if err != nil {
+			return nil
+		}
+		r.searched(1)
+		n += len(matches)
+		return r.results(matches)
+	})
+	return n, err
+}
+
+// grepFile returns the lines of name that match rx.
+func grepFile(name string, rx *regexp.Regexp) ([]match, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var matches []match
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if rx.Match(sc.Bytes()) {
+			matches = append(matches, match{File: name, Line: line, Text: sc.Text()})
+		}
+	}
+	return matches, sc.Err()
+}
+
+// goToProto sends to r the locations of the proto definitions from which the
+// Go type (or method) named what was generated.
+func goToProto(r *replier, what string, scopes []searchScope) error {
+	pfs, err := protoFiles(scopes)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, pf := range pfs {
+		for _, s := range pf.symbols {
+			for _, name := range s.goNames() {
+				if name != what && "Unimplemented"+name != what {
+					continue
+				}
+				if err := r.result(pf.name, s.line, " "+s.kind+" "+s.name()); err != nil {
+					return err
+				}
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("no proto definition for Go %s", what)
+	}
+	return nil
+}
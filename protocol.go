@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// protocolVersion is the version of the protocol between the clients and the
+// search server. The server rejects the requests of any other version.
+const protocolVersion = 1
+
+// request is what a client sends to the server, gob encoded, to start a
+// search. Several requests can be sent in turn on the same connection.
+type request struct {
+	Version int
+	// ID is chosen by the client, and the server sets it on all the
+	// responses for that request.
+	ID      uint64
+	Action  int
+	Project string
+	What    string
+	// Where, if set, is the path, or the label, of the location of Project
+	// to search, instead of all of them.
+	Where   string
+	Lang    string // for decl, resolve, protoMap, and asmMap
+	Decl    string // for decl and tagsLookup: the kind of declaration
+	From    string // for resolve: the importing file
+	Options searchOptions
+	// Client is the id that the client got from doRegister, if any. The
+	// request is then served with the projects of its configuration file,
+	// instead of with the ones of the server.
+	Client uint64
+	Config string // for doRegister: the absolute path of the configuration file
+	Window int    // for doRegister: the id of the acme window, if any
+	// Cancel is, for doKill, the IDs of the requests of the same Client to
+	// stop.
+	Cancel []uint64
+}
+
+// searchOptions are the optional settings of a search, which override the
+// ones from the configuration.
+type searchOptions struct {
+	// IgnoreCase makes the match case insensitive.
+	IgnoreCase bool
+	// Exts, if not empty, replaces the Exts of the project (or of the
+	// language) for the search.
+	Exts []string
+	// Excluded is added to the Excluded patterns of the project.
+	Excluded []string
+}
+
+// Kinds of response.
+const (
+	// responseError carries an error. More responses can follow.
+	responseError = iota
+	// responseDone is always the last response for a request.
+	responseDone
+	// responseProjects carries the projects, for doGetProjects.
+	responseProjects
+	// responseResults carries some matches of a search.
+	responseResults
+	// responseRegistered carries the client id, for doRegister.
+	responseRegistered
+	// responseStats carries the metrics of each project, for doStats.
+	responseStats
+)
+
+// Terminal statuses of a request.
+const (
+	statusOK        = "ok"
+	statusFailed    = "failed"
+	statusCancelled = "cancelled"
+)
+
+// response is what the server sends back, gob encoded, for a request.
+type response struct {
+	ID       uint64
+	Kind     int
+	Err      *replyError        // for responseError
+	Status   string             // for responseDone
+	Summary  *searchSummary     // for responseDone
+	Projects map[string]Project // for responseProjects
+	Matches  []match            // for responseResults
+	Client   uint64             // for responseRegistered
+	Stats    []projectStats     // for responseStats
+}
+
+// match is a search result.
+type match struct {
+	File string
+	Line int // 0 if unknown
+	Text string
+}
+
+// String returns m in the same format as grep -n.
+func (m match) String() string {
+	if m.Line == 0 {
+		return m.File + ":" + m.Text
+	}
+	return m.File + ":" + strconv.Itoa(m.Line) + ":" + m.Text
+}
+
+// parseGrepOutput returns the matches from the output of grep -n --null, where
+// a NUL ends the file name, which can have colons. The output is read as a
+// whole, however long its lines.
+func parseGrepOutput(out []byte) []match {
+	var matches []match
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		i := strings.IndexByte(line, 0)
+		if i < 0 {
+			// e.g. "Binary file foo matches"
+			continue
+		}
+		file, rest := line[:i], line[i+1:]
+		parts := strings.SplitN(rest, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			matches = append(matches, match{File: file, Text: rest})
+			continue
+		}
+		matches = append(matches, match{File: file, Line: n, Text: parts[1]})
+	}
+	return matches
+}
+
+// searchSummary is sent with the terminal response of a search. It holds its
+// metrics, some of which are only known for the find and grep searches.
+type searchSummary struct {
+	Matches  int
+	Files    int // number of files searched, when known
+	Duration time.Duration
+
+	Walked   int   // number of files walked
+	Excluded int   // number of files walked, but filtered out by Exts or Excluded
+	Bytes    int64 // size of the files searched
+	// Walk is the time until the walk was over, and Match the time spent
+	// matching. They overlap, since matching starts during the walk.
+	Walk  time.Duration
+	Match time.Duration
+}
+
+func (s *searchSummary) String() string {
+	str := fmt.Sprintf("%d matches in %d files, in %v", s.Matches, s.Files, s.Duration)
+	if s.Walked == 0 {
+		return str
+	}
+	return str + fmt.Sprintf("; %d files walked, %d excluded, %d bytes read; %v walking, %v matching",
+		s.Walked, s.Excluded, s.Bytes, s.Walk, s.Match)
+}
+
+// errorCode is the type of the error in a reply.
+type errorCode int
+
+const (
+	errBadRequest errorCode = iota + 1
+	errVersion
+	errUnknownProject
+	errUnknownLocation
+	errUnknownLanguage
+	errNotFound
+	errSearch
+)
+
+var errorCodeNames = map[errorCode]string{
+	errBadRequest:      "bad request",
+	errVersion:         "unsupported protocol version",
+	errUnknownProject:  "unknown project",
+	errUnknownLocation: "unknown location",
+	errUnknownLanguage: "unknown language",
+	errNotFound:        "not found",
+	errSearch:          "search failed",
+}
+
+func (c errorCode) String() string {
+	if s, ok := errorCodeNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("error %d", int(c))
+}
+
+// replyError is an error reported to the client.
+type replyError struct {
+	Code    errorCode
+	Message string
+}
+
+func (e *replyError) Error() string {
+	return e.Code.String() + ": " + e.Message
+}
+
+func replyErrorf(code errorCode, format string, args ...interface{}) *replyError {
+	return &replyError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// asReplyError returns err as a replyError, with code if it is not one
+// already.
+func asReplyError(code errorCode, err error) *replyError {
+	if rerr, ok := err.(*replyError); ok {
+		return rerr
+	}
+	return &replyError{Code: code, Message: err.Error()}
+}
+
+// replier sends the responses for a request. It is safe for concurrent use.
+// Each send blocks until the response is written to the connection, so a
+// search can never get ahead of a slow client by more than the socket
+// buffers: the search is simply stalled until the client catches up.
+type replier struct {
+	// ctx is done when the search should stop: when the client went
+	// away, or when a response could not be sent.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	enc   *gob.Encoder
+	id    uint64
+	start time.Time
+
+	// sums up what was sent so far, and the metrics.
+	sum searchSummary
+}
+
+func newReplier(ctx context.Context, enc *gob.Encoder, id uint64) *replier {
+	ctx, cancel := context.WithCancel(ctx)
+	return &replier{ctx: ctx, cancel: cancel, enc: enc, id: id, start: time.Now()}
+}
+
+func (r *replier) send(resp response) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resp.ID = r.id
+	if err := r.enc.Encode(resp); err != nil {
+		r.cancel()
+		return err
+	}
+	return nil
+}
+
+func (r *replier) error(err *replyError) error {
+	return r.send(response{Kind: responseError, Err: err})
+}
+
+// results sends matches, if any.
+func (r *replier) results(matches []match) error {
+	if len(matches) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	r.sum.Matches += len(matches)
+	r.mu.Unlock()
+	return r.send(response{Kind: responseResults, Matches: matches})
+}
+
+// result sends a single match.
+func (r *replier) result(file string, line int, text string) error {
+	return r.results([]match{{File: file, Line: line, Text: text}})
+}
+
+// searched records that n more files were searched.
+func (r *replier) searched(n int) {
+	r.mu.Lock()
+	r.sum.Files += n
+	r.mu.Unlock()
+}
+
+// addStats adds the metrics in s, but for Matches and Files, to the ones of
+// the search.
+func (r *replier) addStats(s searchSummary) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sum.Walked += s.Walked
+	r.sum.Excluded += s.Excluded
+	r.sum.Bytes += s.Bytes
+	r.sum.Walk += s.Walk
+	r.sum.Match += s.Match
+}
+
+// summary returns the summary of the search so far.
+func (r *replier) summary() *searchSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.sum
+	summary.Duration = time.Since(r.start)
+	return &summary
+}
+
+func (r *replier) done(status string) error {
+	return r.send(response{Kind: responseDone, Status: status, Summary: r.summary()})
+}
+
+var lastRequestID uint64
+
+func newRequestID() uint64 {
+	return atomic.AddUint64(&lastRequestID, 1)
+}
@@ -2,19 +2,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/gob"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"9fans.net/go/plan9"
 	"9fans.net/go/plumb"
@@ -23,27 +26,11 @@ import (
 var (
 	globalProj        = ""
 	projects          map[string]Project
+	languages         map[string]Language
 	filePathValidator *regexp.Regexp
 )
 
-type msg struct {
-	Action  int
-	Project string
-	What    string
-	Where   string
-}
-
-type response struct {
-	body string // JSON string
-}
-
-func listen(c chan int) {
-	ln, err := net.Listen("tcp", ":"+*port)
-	if err != nil {
-		log.Printf("listen error: %v", err)
-		c <- 1
-		return
-	}
+func listen(ln net.Listener, c chan int) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -52,69 +39,286 @@ func listen(c chan int) {
 			return
 		}
 		go serve(conn)
-		log.Printf("********")
 	}
 }
 
-func serveProjects(conn net.Conn) error {
-	return json.NewEncoder(conn).Encode(projects)
-}
-
 func serve(conn net.Conn) {
 	defer conn.Close()
-	var m msg
-	dec := gob.NewDecoder(conn)
-	err := dec.Decode(&m)
-	if err != nil {
-		log.Fatal("decode error:", err)
-	}
-	if m.Action == doGetProjects {
-		if err := serveProjects(conn); err != nil {
+	// ctx is cancelled when the client goes away, which stops its search.
+	// Hence the requests are read as soon as they come, and not only once
+	// the previous one is served.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reqs := make(chan request)
+	decodeErr := make(chan error, 1)
+	go func() {
+		dec := gob.NewDecoder(conn)
+		for {
+			var req request
+			if err := dec.Decode(&req); err != nil {
+				cancel()
+				decodeErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	enc := gob.NewEncoder(conn)
+	for {
+		var req request
+		select {
+		case req = <-reqs:
+		case err := <-decodeErr:
+			if err == io.EOF {
+				return
+			}
+			// The gob stream is out of sync after a malformed message,
+			// so there is no recovering this connection. Tell the client
+			// why, and drop it.
+			log.Printf("decode error from client: %v", err)
+			r := newReplier(context.Background(), enc, req.ID)
+			if err := r.error(replyErrorf(errBadRequest, "could not decode request: %v", err)); err == nil {
+				r.done(statusFailed)
+			}
+			return
+		}
+		r := newReplier(ctx, enc, req.ID)
+		key := searchKey{client: req.Client, id: req.ID}
+		startSearch(key, r)
+		served := make(chan struct{})
+		go cutOff(conn, r, served)
+		status := statusOK
+		if err := safeHandle(r, &req); err != nil {
+			status = statusFailed
+			if r.ctx.Err() != nil {
+				status = statusCancelled
+			} else if err := r.error(asReplyError(errSearch, err)); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		err := r.done(status)
+		close(served)
+		endSearch(key)
+		r.cancel()
+		if err != nil {
 			log.Print(err)
+			return
 		}
+	}
+}
+
+// searchKey identifies a request being served. The IDs are chosen by the
+// clients, hence the client id.
+type searchKey struct {
+	client, id uint64
+}
+
+var (
+	searchesMu sync.Mutex
+	// searches are the requests being served, for doKill.
+	searches = make(map[searchKey]*replier)
+)
+
+func startSearch(key searchKey, r *replier) {
+	searchesMu.Lock()
+	defer searchesMu.Unlock()
+	searches[key] = r
+}
+
+func endSearch(key searchKey) {
+	searchesMu.Lock()
+	defer searchesMu.Unlock()
+	delete(searches, key)
+}
+
+// killSearches stops the requests ids of client, if they are still being
+// served.
+func killSearches(client uint64, ids []uint64) {
+	searchesMu.Lock()
+	defer searchesMu.Unlock()
+	for _, id := range ids {
+		if r, ok := searches[searchKey{client: client, id: id}]; ok {
+			r.cancel()
+		}
+	}
+}
+
+// cutOff drops conn when r was stopped, but is still stuck a second later,
+// sending to a client that does not read anymore. served is closed once r
+// is over.
+func cutOff(conn net.Conn, r *replier, served <-chan struct{}) {
+	select {
+	case <-served:
 		return
+	case <-r.ctx.Done():
+	}
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		conn.SetWriteDeadline(time.Now())
+	}
+}
+
+// safeHandle is handle, with a panic turned into an error for the request, so
+// that a bug triggered by one request does not take down the whole server.
+func safeHandle(r *replier, m *request) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("panic while handling request %d: %v\n%s", m.ID, e, debug.Stack())
+			err = replyErrorf(errSearch, "internal error: %v", e)
+		}
+	}()
+	return handle(r, m)
+}
+
+// handle runs the search for m, and sends the responses for it, except for
+// the terminal one.
+func handle(r *replier, m *request) (err error) {
+	if m.Version != protocolVersion {
+		return replyErrorf(errVersion, "got version %d, want %d", m.Version, protocolVersion)
+	}
+	c := lookupClient(m.Client)
+	if c == nil && m.Client != 0 && m.Action != doRegister {
+		// We were restarted since, or it was pruned.
+		return replyErrorf(errBadRequest, "unknown client %d, please Reload", m.Client)
+	}
+	cs := configOf(c)
+	switch m.Action {
+	case doGetProjects:
+		return r.send(response{Kind: responseProjects, Projects: cs.projects})
+	case doRegister:
+		id, err := register(m.Client, m.Config, m.Window)
+		if err != nil {
+			return replyErrorf(errBadRequest, "could not register %v: %v", m.Config, err)
+		}
+		return r.send(response{Kind: responseRegistered, Client: id})
+	case doUnregister:
+		unregister(m.Client)
+		return nil
+	case doKill:
+		killSearches(m.Client, m.Cancel)
+		return nil
+	case doStats:
+		return r.send(response{Kind: responseStats, Stats: allStats()})
 	}
-	proj, ok := projects[m.Project]
+	proj, ok := cs.projects[m.Project]
 	if !ok {
-		log.Print("not a project name: %s \n", m.Project)
-		return
+		return replyErrorf(errUnknownProject, "%s", m.Project)
 	}
-	var where *[]string
 	if m.Where != "" {
-		where = &[]string{m.Where}
-	} else {
-		where = &(proj.Locations)
-	}
-	exts := proj.Exts
-	if len(exts) == 0 {
-		exts = []string{`\.go`}
+		if _, ok := proj.location(m.Where); !ok {
+			return replyErrorf(errUnknownLocation, "%s is not a location of %s", m.Where, m.Project)
+		}
 	}
+	defer func() {
+		recordStats(configFileOf(c), m.Project, m.What, err, r.summary())
+	}()
+	scopes := searchScopes(proj, m.Where, m.Options.Exts, m.Options.Excluded)
+	where := scopesPaths(scopes)
 
 	// TODO(mpl): restore whatever case file was?
 	switch m.Action {
 	case regex:
-		findRegex(m.What, *where, exts, proj.Excluded)
+		for _, s := range scopes {
+			if err := findRegex(r, m.What, m.Options.IgnoreCase, s.locations, s.exts, s.excluded); err != nil {
+				return err
+			}
+		}
+	case decl:
+		l, ok := cs.languages[m.Lang]
+		if !ok {
+			return replyErrorf(errUnknownLanguage, "%s", m.Lang)
+		}
+		reg, ok := l.declRegex(m.Decl, m.What)
+		if !ok {
+			return replyErrorf(errBadRequest, "not a %s declaration kind: %s", m.Lang, m.Decl)
+		}
+		for _, s := range scopes {
+			// Only the files of the language, among the ones of
+			// the scope.
+			exts := commonExts(l.Exts, s.exts)
+			if len(exts) == 0 {
+				continue
+			}
+			if err := findRegex(r, reg, l.IgnoreCase || m.Options.IgnoreCase, s.locations, exts, s.excluded); err != nil {
+				return err
+			}
+		}
+	case resolve:
+		l, ok := cs.languages[m.Lang]
+		if !ok {
+			return replyErrorf(errUnknownLanguage, "%s", m.Lang)
+		}
+		fullPath := resolveImport(m.What, m.From, l.Resolve, where)
+		if fullPath == "" {
+			return replyErrorf(errNotFound, "could not resolve %s import %s", m.Lang, m.What)
+		}
+		if c != nil && c.window != 0 {
+			// Only open it for an acme UI, otherwise the client
+			// does whatever it wants with the result.
+			if err := plumbFile(fullPath); err != nil {
+				return err
+			}
+		}
+		if err := r.result(fullPath, 0, " "+m.What); err != nil {
+			return err
+		}
+	case tagsLookup:
+		if err := searchTags(r, m.What, m.Decl, proj, where); err != nil {
+			return asReplyError(errNotFound, err)
+		}
+	case asmMap:
+		var err error
+		if m.Lang == "asm" {
+			err = asmToGo(r, m.What, scopes)
+		} else {
+			err = goToAsm(r, m.What, scopes)
+		}
+		if err != nil {
+			return asReplyError(errNotFound, err)
+		}
+	case protoMap:
+		var err error
+		if m.Lang == "proto" {
+			err = protoToGo(r, m.What, scopes)
+		} else {
+			err = goToProto(r, m.What, scopes)
+		}
+		if err != nil {
+			return asReplyError(errNotFound, err)
+		}
 	case file:
 		if !filePathValidator.MatchString(m.What) {
-			patternTofileName(m.What, *where)
-		} else {
-			openFile(m.What, *where, false)
+			patternTofileName(m.What, where, cs.projects)
+		} else if err := openFile(m.What, where, false); err != nil {
+			if os.IsNotExist(err) {
+				return replyErrorf(errNotFound, "%s", m.What)
+			}
+			return err
 		}
 	default:
-		println(m.Action, m.What)
+		return replyErrorf(errBadRequest, "unknown action %d", m.Action)
 	}
-	log.Printf("********")
-	w.Write("body", []byte(m.What+"\n"))
+	addHistory(c, m.What)
+	return nil
 }
 
-func patternTofileName(what string, where []string) {
+func patternTofileName(what string, where []string, projects map[string]Project) {
 	// assume it's a class/package/etc name and try to find what's the most usual guess depending on the language
 	// if no project is set, just go through all of them until there's a match
 	if globalProj == "" {
 		for _, v := range projects {
 			for _, s := range v.Exts {
+				if _, ok := isGlob(s); ok {
+					continue
+				}
 				ext := strings.Replace(s, "\\", "", -1)
-				err := openFile(what+ext, v.Locations, false)
+				err := openFile(what+ext, v.paths(), false)
 				if err == nil {
 					return
 				}
@@ -125,6 +329,9 @@ func patternTofileName(what string, where []string) {
 		v, ok := projects[globalProj]
 		if ok {
 			for _, s := range v.Exts {
+				if _, ok := isGlob(s); ok {
+					continue
+				}
 				ext := strings.Replace(s, "\\", "", -1)
 				openFile(what+ext, where, false)
 			}
@@ -138,99 +345,190 @@ const exitStatusOne = "exit status 1"
 // brackets.
 // TODO(mpl): follow symlinks?
 // TODO(mpl): write to acme win once we replace find and grep with native code
-func findRegex(reg string, list []string, exts []string, excl []string) {
-	findProcMu.Lock()
-	defer findProcMu.Unlock()
-	var err error
+//
+// Files that vanish, or that cannot be read, during the search do not stop it,
+// but the first such failure, from find or grep, is returned once the search
+// is over, so the client knows the results are incomplete.
+func findRegex(r *replier, reg string, ignoreCase bool, list []string, exts []string, excl []string) error {
+	filter, err := newPathFilter(exts, excl)
+	if err != nil {
+		return err
+	}
+	// ctx is cancelled when we stop early, which kills find, and grep.
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
 	pr, pw := io.Pipe()
 	defer pr.Close()
 
+	// stats, but for Files, which is recorded in r as we go.
+	var stats searchSummary
+	start := time.Now()
+	defer func() {
+		r.addStats(stats)
+	}()
+
+	findErrc := make(chan error, 1)
 	go func() {
 		nargs := 5
 		args1 := make([]string, 0, nargs+len(list))
 		args1 = append(args1, findBin)
 		args1 = append(args1, list...)
-		exp := ".*(" + strings.Join(exts, "|") + ")$"
-		args1 = append(args1, "-regextype", "posix-egrep", "-regex", exp)
-		if excl != nil {
-			for _, v := range excl {
-				args1 = append(args1, "-a", "!", "-regex", v)
-			}
+		exp := filter.findExts()
+		// For the metrics, find also prints an empty line for each
+		// file filtered out by exts, and a "-" line for each one
+		// filtered out by excl. The others are prefixed with their size.
+		// The globs are left to us.
+		args1 = append(args1, "-regextype", "posix-egrep", "(", "-regex", exp, "(")
+		for _, v := range filter.exclRegexps {
+			args1 = append(args1, "!", "-regex", v, "-a")
 		}
-		cmd := exec.Command(args1[0], args1[1:]...)
+		args1 = append(args1, "-type", "f", "-printf", "%s %p\n", "-o", "-type", "f", "-printf", "-\n", ")", ")",
+			"-o", "-type", "f", "-printf", "\n")
+		cmd := exec.CommandContext(ctx, args1[0], args1[1:]...)
 		cmd.Stdout = pw
-		if err := cmd.Run(); err != nil {
-			log.Fatalf("find failed: %v, %s", err, string(err.(*exec.ExitError).Stderr))
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if err != nil {
+			err = fmt.Errorf("find failed: %v, %s", err, bytes.TrimSpace(stderr.Bytes()))
 		}
+		stats.Walk = time.Since(start)
 		pw.Close()
+		findErrc <- err
 	}()
 
+	// busy is held by the grep in progress, until its results are sent.
+	// Sending blocks until the client reads, which in turn holds the next
+	// grep, and find.
+	busy := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	// sendErr is set when the results could not be sent, e.g. because the
+	// client went away, in which case we stop searching. grepErr is the
+	// first grep failure.
+	var sendErr, grepErr error
+	// grepLines greps lines in the background, once the previous grep is
+	// done. It returns false if we stopped meanwhile.
+	grepLines := func(lines []string) bool {
+		args2 := grepArgs(reg, ignoreCase, lines)
+		r.searched(len(lines))
+		select {
+		case busy <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-busy }()
+			defer wg.Done()
+			grepStart := time.Now()
+			matches, err := grep(ctx, args2)
+			stats.Match += time.Since(grepStart)
+			if err != nil && grepErr == nil {
+				grepErr = err
+			}
+			if err := r.results(matches); err != nil {
+				sendErr = err
+				cancel()
+			}
+		}()
+		return true
+	}
+
 	sc := bufio.NewScanner(pr)
 	var lines []string
-	var mu sync.Mutex
-	var wg sync.WaitGroup
 	for sc.Scan() {
-		killGrepMu.Lock()
-		if killGrep {
-			// TODO(mpl): kill last grep? it's only running over 10 files at max, so kindof ok to let it finish.
-			lines = nil
-			// Consume all of pr to let find finish gracefully
-			if _, err := io.Copy(ioutil.Discard, pr); err != nil {
-				log.Fatal(err)
-			}
-			killGrep = false
-			killGrepMu.Unlock()
+		if ctx.Err() != nil {
 			break
 		}
-		killGrepMu.Unlock()
 		//		println("LINE: ", sc.Text())
-		lines = append(lines, sc.Text())
+		line := sc.Text()
+		stats.Walked++
+		if line == "" || line == "-" {
+			stats.Excluded++
+			continue
+		}
+		sizeAndName := strings.SplitN(line, " ", 2)
+		if len(sizeAndName) != 2 {
+			continue
+		}
+		if filter.hasGlobs() && !filter.selects(locationOf(list, sizeAndName[1]), sizeAndName[1]) {
+			stats.Excluded++
+			continue
+		}
+		if size, err := strconv.ParseInt(sizeAndName[0], 10, 64); err == nil {
+			stats.Bytes += size
+		}
+		lines = append(lines, sizeAndName[1])
 		if len(lines) > 9 {
-			args2 := append([]string{grepBin, "-E", "-n", reg}, lines...)
-			lines = lines[:0]
-			mu.Lock()
-			go func() {
-				defer mu.Unlock()
-				wg.Add(1)
-				defer wg.Done()
-				cmd := exec.Command(args2[0], args2[1:]...)
-				out, err := cmd.Output()
-				if err != nil {
-					// Because exit status 1 is grep simply didn't find anything.
-					if !strings.Contains(err.Error(), exitStatusOne) {
-						// TODO(mpl): be less lazy about that *exec.Error assertion
-						exitErr, ok := err.(*exec.ExitError)
-						if !ok {
-							log.Fatalf("grep call failed (and i was too lazy to record Stderr): %v", err)
-						}
-						log.Fatalf("grep failed: %v, %s", err, string(exitErr.Stderr))
-					}
-					return
-				}
-				fmt.Fprintf(os.Stdout, "%s", string(out))
-			}()
+			if !grepLines(lines) {
+				break
+			}
+			lines = nil
 		}
 	}
+	scanErr := sc.Err()
+	if ctx.Err() == nil && scanErr == nil {
+		if len(lines) > 0 {
+			grepLines(lines)
+		}
+		wg.Wait()
+	}
+	// If we stopped early, find is killed, instead of walking the rest of
+	// the tree for nothing, and closing pr lets it go.
+	cancel()
+	pr.Close()
 	wg.Wait()
-	if err := sc.Err(); err != nil {
-		log.Fatal(err)
+	findErr := <-findErrc
+	if sendErr != nil {
+		return sendErr
 	}
-	if len(lines) <= 0 {
-		return
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if findErr != nil {
+		log.Print(findErr)
+		if scanErr == nil {
+			scanErr = findErr
+		}
 	}
+	if grepErr != nil {
+		return grepErr
+	}
+	return scanErr
+}
 
-	// TODO(mpl): refactor as func
-	args2 := append([]string{grepBin, "-E", "-n", reg}, lines...)
-	cmd := exec.Command(args2[0], args2[1:]...)
+// grep runs the grep command in args, and returns the matches it found, even
+// when it also failed, e.g. because one of the files vanished. It is killed
+// when ctx is done.
+func grep(ctx context.Context, args []string) ([]match, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	out, err := cmd.Output()
-	if err != nil {
-		// Because exit status 1 is grep simply didn't find anything.
-		if !strings.Contains(err.Error(), exitStatusOne) {
-			log.Fatalf("grep failed: %v, %s", err, string(err.(*exec.ExitError).Stderr))
-		}
-		return
+	if err == nil {
+		return parseGrepOutput(out), nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// Because exit status 1 is grep simply didn't find anything.
+	if strings.Contains(err.Error(), exitStatusOne) {
+		return nil, nil
 	}
-	fmt.Fprintf(os.Stdout, "%s", string(out))
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		log.Printf("grep failed: %v, %s", err, exitErr.Stderr)
+		return parseGrepOutput(out), fmt.Errorf("grep failed: %v, %s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return nil, fmt.Errorf("grep failed: %v", err)
+}
+
+func grepArgs(reg string, ignoreCase bool, files []string) []string {
+	// -H, for the file name even when there is only one file, and --null
+	// to end it, since it can have colons.
+	args := []string{grepBin, "-E", "-n", "-H", "--null"}
+	if ignoreCase {
+		args = append(args, "-i")
+	}
+	args = append(args, "-e", reg)
+	return append(args, files...)
 }
 
 func findFile(relPath string, list []string) string {
@@ -253,16 +551,18 @@ func findFile(relPath string, list []string) string {
 				continue
 			}
 			names, err := currentDir.Readdirnames(-1)
+			currentDir.Close()
 			if err != nil {
-				log.Fatal(err)
+				log.Print(err)
+				continue
 			}
-			currentDir.Close()
 			var fi os.FileInfo
 			for _, name := range names {
 				fullPath = path.Join(includeDir, name)
 				fi, err = os.Lstat(fullPath)
 				if err != nil {
-					log.Fatal(err)
+					// it vanished since we listed it. move on.
+					continue
 				}
 				if fi.IsDir() {
 					// recurse
@@ -297,17 +597,19 @@ func findDir(relPath string, list []string) string {
 				continue
 			}
 			names, err := currentDir.Readdirnames(-1)
+			currentDir.Close()
 			if err != nil {
-				log.Fatal(err)
+				log.Print(err)
+				continue
 			}
-			currentDir.Close()
 			var fi os.FileInfo
 			newdir := ""
 			for _, name := range names {
 				newdir = path.Join(includeDir, name)
 				fi, err = os.Lstat(newdir)
 				if err != nil {
-					log.Fatal(err)
+					// it vanished since we listed it. move on.
+					continue
 				}
 				if fi.IsDir() {
 					fullPath = path.Join(newdir, relPath)
@@ -337,9 +639,14 @@ func openFile(relPath string, list []string, isDir bool) error {
 	if fullPath == "" {
 		return os.ErrNotExist
 	}
+	return plumbFile(fullPath)
+}
+
+// plumbFile sends fullPath to the plumber, for opening in the editor.
+func plumbFile(fullPath string) error {
 	port, err := plumb.Open("send", plan9.OWRITE)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer port.Close()
 	msg := &plumb.Message{
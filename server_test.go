@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer serves projects in the background, and returns the client side
+// of the connection.
+func testServer(t *testing.T, projects map[string]Project) net.Conn {
+	t.Helper()
+	old := currentConfig.Load()
+	currentConfig.Store(&configSet{projects: projects})
+	server, client := net.Pipe()
+	go serve(server)
+	t.Cleanup(func() {
+		client.Close()
+		if old != nil {
+			currentConfig.Store(old)
+		}
+	})
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	return client
+}
+
+// readAll reads the responses on conn until the terminal one, which must come.
+func readAll(t *testing.T, dec *gob.Decoder) []response {
+	t.Helper()
+	var all []response
+	for {
+		var resp response
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("no terminal response: %v, after %v", err, all)
+		}
+		all = append(all, resp)
+		if resp.Kind == responseDone {
+			return all
+		}
+	}
+}
+
+// gobUint encodes x as gob does, e.g. for the length of a message.
+func gobUint(x uint64) []byte {
+	if x < 0x80 {
+		return []byte{byte(x)}
+	}
+	var b []byte
+	for ; x > 0; x >>= 8 {
+		b = append([]byte{byte(x)}, b...)
+	}
+	return append([]byte{byte(-len(b))}, b...)
+}
+
+// gobMessages splits the gob stream data into the payloads of its messages.
+func gobMessages(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var msgs [][]byte
+	for len(data) > 0 {
+		n, size := uint64(data[0]), 1
+		if n >= 0x80 {
+			size = 1 + int(-int8(data[0]))
+			n = 0
+			for _, c := range data[1:size] {
+				n = n<<8 | uint64(c)
+			}
+		}
+		if uint64(len(data)) < uint64(size)+n {
+			t.Fatalf("bad gob stream")
+		}
+		msgs = append(msgs, data[size:size+int(n)])
+		data = data[size+int(n):]
+	}
+	return msgs
+}
+
+func checkBadRequest(t *testing.T, client net.Conn, frames []byte) {
+	t.Helper()
+	go client.Write(frames)
+	all := readAll(t, gob.NewDecoder(client))
+	if len(all) != 2 || all[0].Kind != responseError || all[0].Err == nil || all[0].Err.Code != errBadRequest {
+		t.Fatalf("got %+v, want a bad request error", all)
+	}
+	if all[1].Status != statusFailed {
+		t.Errorf("got status %q, want %q", all[1].Status, statusFailed)
+	}
+}
+
+// checkServing checks that a new connection is still served.
+func checkServing(t *testing.T) {
+	t.Helper()
+	client := testServer(t, map[string]Project{"foo": {Name: "foo"}})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: doGetProjects}); err != nil {
+		t.Fatal(err)
+	}
+	all := readAll(t, gob.NewDecoder(client))
+	if all[0].Kind != responseProjects || all[len(all)-1].Status != statusOK {
+		t.Fatalf("got %+v, want the projects", all)
+	}
+}
+
+func TestServeGarbage(t *testing.T) {
+	for _, frames := range [][]byte{
+		{3, 0xff, 0xff, 0xff},
+		append(gobUint(12), "not a frame."...),
+	} {
+		checkBadRequest(t, testServer(t, nil), frames)
+		checkServing(t)
+	}
+}
+
+func TestServeTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := gobMessages(t, buf.Bytes())
+	last := len(msgs) - 1
+	for _, cut := range []int{1, len(msgs[last]) / 2} {
+		var frames []byte
+		for _, msg := range msgs[:last] {
+			frames = append(frames, gobUint(uint64(len(msg)))...)
+			frames = append(frames, msg...)
+		}
+		frames = append(frames, gobUint(uint64(cut))...)
+		frames = append(frames, msgs[last][:cut]...)
+		checkBadRequest(t, testServer(t, nil), frames)
+		checkServing(t)
+	}
+}
+
+func requireGNU(t *testing.T) {
+	t.Helper()
+	if err := checkGNU(); err != nil {
+		t.Skip(err)
+	}
+}
+
+// vanishingTree creates, in a new directory, files that match "needle", and
+// gone* files that do too, but that are removed by the first grep.
+func vanishingTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < 25; i++ {
+		for _, name := range []string{"keep", "gone"} {
+			file := filepath.Join(dir, fmt.Sprintf("%s%02d.txt", name, i))
+			if err := ioutil.WriteFile(file, []byte("a needle\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	script := filepath.Join(t.TempDir(), "grep")
+	if err := ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\nrm -f %s/gone*\nexec %s \"$@\"\n", dir, grepBin)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := grepBin
+	grepBin = script
+	t.Cleanup(func() { grepBin = old })
+	return dir
+}
+
+func TestFindRegexVanishingFiles(t *testing.T) {
+	requireGNU(t)
+	dir := vanishingTree(t)
+	client := testServer(t, map[string]Project{
+		"foo": {Name: "foo", Locations: []Location{{Path: dir}}, Exts: []string{`\.txt`}},
+	})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	var errs []*replyError
+	all := readAll(t, gob.NewDecoder(client))
+	for _, resp := range all {
+		switch resp.Kind {
+		case responseResults:
+			for _, m := range resp.Matches {
+				if !strings.HasPrefix(filepath.Base(m.File), "keep") {
+					t.Errorf("match in a file that was removed: %v", m)
+				}
+				kept++
+			}
+		case responseError:
+			errs = append(errs, resp.Err)
+		}
+	}
+	if kept != 25 {
+		t.Errorf("got %d matches, want 25", kept)
+	}
+	if len(errs) != 1 || errs[0].Code != errSearch {
+		t.Errorf("got errors %v, want one search error about the removed files", errs)
+	}
+	if done := all[len(all)-1]; done.Status != statusFailed || done.Summary == nil || done.Summary.Matches != 25 {
+		t.Errorf("got terminal response %+v, want a failed one with 25 matches", done)
+	}
+	checkServing(t)
+}
+
+func TestFindRegexVanishingLocation(t *testing.T) {
+	requireGNU(t)
+	dir := vanishingTree(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+	client := testServer(t, map[string]Project{
+		"foo": {Name: "foo", Locations: []Location{{Path: missing}, {Path: dir}}, Exts: []string{`\.txt`}},
+	})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+	all := readAll(t, gob.NewDecoder(client))
+	if done := all[len(all)-1]; done.Status != statusFailed || done.Summary == nil || done.Summary.Matches != 25 {
+		t.Errorf("got terminal response %+v, want a failed one with 25 matches", done)
+	}
+}
+
+// removeWhile removes the dirs in the background until stop is closed.
+func removeWhile(dirs []string, stop chan struct{}) {
+	go func() {
+		for _, dir := range dirs {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.RemoveAll(dir)
+		}
+	}()
+}
+
+func TestFindFileVanishingDirs(t *testing.T) {
+	root := t.TempDir()
+	var dirs []string
+	for i := 0; i < 200; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%03d", i), "sub")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, filepath.Dir(dir))
+	}
+	want := filepath.Join(root, "zz", "target", "file.go")
+	if err := os.MkdirAll(filepath.Dir(want), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(want, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	removeWhile(dirs, stop)
+	for i := 0; i < 20; i++ {
+		if got := findFile("file.go", []string{root}); got != want {
+			t.Fatalf("findFile: got %q, want %q", got, want)
+		}
+		if got := findDir("target", []string{root}); got != filepath.Dir(want) {
+			t.Fatalf("findDir: got %q, want %q", got, filepath.Dir(want))
+		}
+	}
+	if got := findFile("nothere.go", []string{root, filepath.Join(root, "missing")}); got != "" {
+		t.Errorf("findFile: got %q for a missing file", got)
+	}
+	if got := findDir("nothere", []string{filepath.Join(root, "missing"), root}); got != "" {
+		t.Errorf("findDir: got %q for a missing dir", got)
+	}
+}
+
+func TestServeUnknownLocation(t *testing.T) {
+	dir := t.TempDir()
+	client := testServer(t, map[string]Project{
+		"foo": {Name: "foo", Locations: []Location{{Path: dir}}},
+	})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "root", Where: "/etc"}); err != nil {
+		t.Fatal(err)
+	}
+	all := readAll(t, gob.NewDecoder(client))
+	if len(all) != 2 || all[0].Err == nil || all[0].Err.Code != errUnknownLocation {
+		t.Fatalf("got %+v, want an unknown location error", all)
+	}
+	if done := all[1]; done.Summary == nil || done.Summary.Walked != 0 {
+		t.Errorf("got terminal response %+v, want a summary with no files walked", done)
+	}
+}
+
+func TestFindRegexGlobDirs(t *testing.T) {
+	requireGNU(t)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "subsub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("a needle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := testServer(t, map[string]Project{
+		"foo": {Name: "foo", Locations: []Location{{Path: dir}}, Exts: []string{"glob:sub/**"}},
+	})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+	all := readAll(t, gob.NewDecoder(client))
+	if done := all[len(all)-1]; done.Status != statusOK || done.Summary == nil || done.Summary.Matches != 1 {
+		t.Errorf("got %+v, want one match, and no error about the directories", all)
+	}
+}
+
+func TestFindRegexColonInName(t *testing.T) {
+	requireGNU(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a:12:b.txt")
+	if err := ioutil.WriteFile(file, []byte("nothing\nx: a needle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := testServer(t, map[string]Project{
+		"foo": {Name: "foo", Locations: []Location{{Path: dir}}, Exts: []string{`\.txt`}},
+	})
+	if err := gob.NewEncoder(client).Encode(request{Version: protocolVersion, ID: 1, Action: regex, Project: "foo", What: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+	var matches []match
+	for _, resp := range readAll(t, gob.NewDecoder(client)) {
+		matches = append(matches, resp.Matches...)
+	}
+	if len(matches) != 1 || matches[0].File != file || matches[0].Line != 2 || matches[0].Text != "x: a needle" {
+		t.Errorf("got %+v, want line 2 of %v", matches, file)
+	}
+}
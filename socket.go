@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"9fans.net/go/plan9/client"
+)
+
+// socketName is the name of our Unix domain socket, in socketDir.
+const socketName = "gofinder"
+
+// socketDir returns the directory where our Unix domain socket lives, which
+// is $XDG_RUNTIME_DIR if set, or the plan9port namespace directory otherwise.
+// Both are only accessible to the user.
+func socketDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir, nil
+	}
+	if ns := client.Namespace(); ns != "" {
+		return ns, nil
+	}
+	return "", errors.New("neither XDG_RUNTIME_DIR nor a plan9port namespace directory is available")
+}
+
+func socketPath() (string, error) {
+	dir, err := socketDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, socketName), nil
+}
+
+// serverAddr returns the network and address that the server listens on: a
+// TCP port on localhost if -p was given (any free one for 0), and our Unix
+// domain socket otherwise.
+func serverAddr() (network, addr string, err error) {
+	if *port != "" {
+		return "tcp", net.JoinHostPort("localhost", *port), nil
+	}
+	path, err := socketPath()
+	if err != nil {
+		return "", "", err
+	}
+	return "unix", path, nil
+}
+
+// newListener returns the listener for the server. The Unix domain socket is
+// only accessible to the user.
+func newListener() (net.Listener, error) {
+	network, addr, err := serverAddr()
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+	if err := os.MkdirAll(filepath.Dir(addr), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(addr); err == nil {
+		// Do not steal the socket of a running server, but clean up
+		// after a dead one.
+		if c, err := net.Dial("unix", addr); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("a server is already listening on %v", addr)
+		}
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// dialServer connects to the server: the one advertised for our
+// configuration file, if any, or the one at serverAddr otherwise.
+func dialServer() (net.Conn, error) {
+	if *port == "" || *port == "0" {
+		if conn, err := dialDiscovered(configFile); err == nil {
+			return conn, nil
+		}
+	}
+	if *port == "0" {
+		return nil, fmt.Errorf("no server found for %v", configFile)
+	}
+	network, addr, err := serverAddr()
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial(network, addr)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// projectStats are the metrics of all the searches in a project, since the
+// server started.
+type projectStats struct {
+	// Config is the configuration file that defines Project, since two of
+	// them can have a project of the same name.
+	Config   string
+	Project  string
+	Searches int
+	Failed   int
+	Matches  int
+	Files    int
+	Walked   int
+	Excluded int
+	Bytes    int64
+	Duration time.Duration
+	Walk     time.Duration
+	Match    time.Duration
+	// Slowest is the duration of the slowest search, and SlowestWhat what
+	// it was looking for.
+	Slowest     time.Duration
+	SlowestWhat string
+}
+
+func (s projectStats) String() string {
+	str := fmt.Sprintf("%s (%s): %d searches (%d failed), %d matches in %d files, in %v",
+		s.Project, s.Config, s.Searches, s.Failed, s.Matches, s.Files, s.Duration)
+	if s.Walked > 0 {
+		str += fmt.Sprintf("; %d files walked, %d excluded, %d bytes read; %v walking, %v matching",
+			s.Walked, s.Excluded, s.Bytes, s.Walk, s.Match)
+	}
+	if s.Searches > 0 {
+		str += fmt.Sprintf("; slowest: %q, in %v", s.SlowestWhat, s.Slowest)
+	}
+	return str
+}
+
+// statsKey identifies a project among the ones of all the configuration
+// files.
+type statsKey struct {
+	config  string
+	project string
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = make(map[statsKey]*projectStats)
+)
+
+// recordStats adds the summary of a search for what in project, of the
+// configuration file config, to the metrics of project. err is the error of
+// the search, if any.
+func recordStats(config, project, what string, err error, s *searchSummary) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	key := statsKey{config: config, project: project}
+	ps, ok := stats[key]
+	if !ok {
+		ps = &projectStats{Config: config, Project: project}
+		stats[key] = ps
+	}
+	ps.Searches++
+	if err != nil {
+		ps.Failed++
+	}
+	ps.Matches += s.Matches
+	ps.Files += s.Files
+	ps.Walked += s.Walked
+	ps.Excluded += s.Excluded
+	ps.Bytes += s.Bytes
+	ps.Duration += s.Duration
+	ps.Walk += s.Walk
+	ps.Match += s.Match
+	if s.Duration > ps.Slowest {
+		ps.Slowest = s.Duration
+		ps.SlowestWhat = what
+	}
+}
+
+// allStats returns the metrics of all the projects, sorted by project name,
+// and then by configuration file.
+func allStats() []projectStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	all := make([]projectStats, 0, len(stats))
+	for _, ps := range stats {
+		all = append(all, *ps)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Project != all[j].Project {
+			return all[i].Project < all[j].Project
+		}
+		return all[i].Config < all[j].Config
+	})
+	return all
+}
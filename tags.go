@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tagsKeyword is the name of the UI line for the tags lookups.
+	tagsKeyword = "tags"
+	// allKinds is the tags lookup command that does not filter on kind.
+	allKinds = "all"
+)
+
+// tag is an entry of a tags file.
+type tag struct {
+	name string
+	file string // absolute
+	kind string // as found in the tags file, e.g. "f" or "function". Empty for etags.
+	line int    // 0 if unknown, in which case pattern is set.
+	// pattern is the ex search command (without the delimiters) that finds the
+	// tag in file.
+	pattern string
+}
+
+// tagsFile is a loaded tags file.
+type tagsFile struct {
+	modTime time.Time
+	size    int64
+	tags    map[string][]tag
+	kinds   map[string]bool
+}
+
+var (
+	tagsMu sync.Mutex
+	// tagsCache maps the path of a tags file to its contents, as of when it
+	// was last modified.
+	tagsCache = make(map[string]*tagsFile)
+)
+
+// tagsPath returns the path of the tags file configured in p for location,
+// or "" if there is none.
+func tagsPath(p Project, location string) string {
+	tf, ok := p.Tags[location]
+	if !ok || tf == "" {
+		return ""
+	}
+	if filepath.IsAbs(tf) {
+		return tf
+	}
+	return filepath.Join(location, tf)
+}
+
+// loadTags returns the contents of the tags file at path, which is parsed
+// again only if it changed since the last time.
+func loadTags(path string) (*tagsFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	if tf, ok := tagsCache[path]; ok && tf.modTime.Equal(fi.ModTime()) && tf.size == fi.Size() {
+		return tf, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tf := &tagsFile{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+		tags:    make(map[string][]tag),
+		kinds:   make(map[string]bool),
+	}
+	dir := filepath.Dir(path)
+	if len(data) > 0 && data[0] == '\f' {
+		tf.parseEtags(data, dir)
+	} else {
+		tf.parseCtags(data, dir)
+	}
+	tagsCache[path] = tf
+	return tf, nil
+}
+
+func (tf *tagsFile) add(t tag) {
+	tf.tags[t.name] = append(tf.tags[t.name], t)
+	if t.kind != "" {
+		tf.kinds[t.kind] = true
+	}
+}
+
+// parseCtags parses data in the Exuberant/Universal ctags format:
+//
+//	name<TAB>file<TAB>address;"<TAB>kind<TAB>field:value...
+//
+// where address is either a line number or a /^pattern$/ search.
+func (tf *tagsFile) parseCtags(data []byte, dir string) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "!_TAG_") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		t := tag{
+			name: fields[0],
+			file: fields[1],
+		}
+		if !filepath.IsAbs(t.file) {
+			t.file = filepath.Join(dir, t.file)
+		}
+		address, ext := fields[2], ""
+		if i := strings.LastIndex(address, `;"`); i >= 0 {
+			address, ext = address[:i], address[i+2:]
+		}
+		if n, err := strconv.Atoi(address); err == nil {
+			t.line = n
+		} else if len(address) > 1 && (address[0] == '/' || address[0] == '?') {
+			t.pattern = unescapeTagPattern(address[1 : len(address)-1])
+		}
+		for _, f := range strings.Split(ext, "\t") {
+			if f == "" {
+				continue
+			}
+			if !strings.Contains(f, ":") {
+				t.kind = f
+				continue
+			}
+			kv := strings.SplitN(f, ":", 2)
+			switch kv[0] {
+			case "kind":
+				t.kind = kv[1]
+			case "line":
+				if n, err := strconv.Atoi(kv[1]); err == nil {
+					t.line = n
+				}
+			}
+		}
+		tf.add(t)
+	}
+}
+
+// unescapeTagPattern turns a ctags search pattern into the literal line it
+// matches, with a leading "^" and a trailing "$" kept as anchors.
+func unescapeTagPattern(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) {
+			i++
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// parseEtags parses data in the etags (TAGS) format, where each file section
+// starts with a form feed line, followed by a "file,size" line, then by one
+// "text<DEL>name<SOH>line,offset" line per tag, where the name part is
+// optional.
+func (tf *tagsFile) parseEtags(data []byte, dir string) {
+	var file string
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if line == "\f" {
+			if i+1 < len(lines) {
+				i++
+				file = lines[i]
+				if j := strings.LastIndex(file, ","); j >= 0 {
+					file = file[:j]
+				}
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(dir, file)
+				}
+			}
+			continue
+		}
+		del := strings.Index(line, "\x7f")
+		if del < 0 || file == "" {
+			continue
+		}
+		text, rest := line[:del], line[del+1:]
+		name := ""
+		if j := strings.Index(rest, "\x01"); j >= 0 {
+			name, rest = rest[:j], rest[j+1:]
+		} else {
+			name = etagsImplicitName(text)
+		}
+		if name == "" {
+			continue
+		}
+		t := tag{name: name, file: file}
+		if n, err := strconv.Atoi(strings.SplitN(rest, ",", 2)[0]); err == nil {
+			t.line = n
+		}
+		tf.add(t)
+	}
+}
+
+// etagsImplicitName returns the name of a tag that has no explicit name,
+// which is the last identifier in its text.
+func etagsImplicitName(text string) string {
+	text = strings.TrimRight(text, " \t(=,;{")
+	i := strings.LastIndexFunc(text, func(r rune) bool {
+		return !(r == '_' || r == '$' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	return text[i+1:]
+}
+
+// tagKinds returns all the kinds of tags, sorted, found in the tags files of
+// p. The UI only asks when it draws the window, so a kind added to the tags
+// files since then only shows up once it is drawn again, e.g. on Reload.
+func tagKinds(p Project) []string {
+	kinds := make(map[string]bool)
+	for _, l := range p.paths() {
+		path := tagsPath(p, l)
+		if path == "" {
+			continue
+		}
+		tf, err := loadTags(path)
+		if err != nil {
+			continue
+		}
+		for k := range tf.kinds {
+			kinds[k] = true
+		}
+	}
+	var sorted []string
+	for k := range kinds {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// searchTags sends to r the tags named what, and of the given kind unless kind
+// is allKinds, from the tags files of p for locations. A tags file that can't
+// be loaded is skipped, and only fails the search if all of them are.
+func searchTags(r *replier, what, kind string, p Project, locations []string) error {
+	found := false
+	loaded := 0
+	var loadErr error
+	for _, l := range locations {
+		path := tagsPath(p, l)
+		if path == "" {
+			continue
+		}
+		tf, err := loadTags(path)
+		if err != nil {
+			log.Printf("skipping tags file %v: %v", path, err)
+			if loadErr == nil {
+				loadErr = err
+			}
+			continue
+		}
+		loaded++
+		for _, t := range tf.tags[what] {
+			if kind != allKinds && t.kind != kind {
+				continue
+			}
+			found = true
+			line := t.line
+			if line == 0 {
+				line = tagLine(t.file, t.pattern)
+			}
+			text := " " + strings.TrimSpace(t.kind+" "+t.name)
+			if line == 0 {
+				text += " (not found)"
+			}
+			if err := r.result(t.file, line, text); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		if loaded == 0 && loadErr != nil {
+			return loadErr
+		}
+		return fmt.Errorf("no %s tag for %s", kind, what)
+	}
+	return nil
+}
+
+// tagLine returns the number of the first line of file that matches the
+// ctags pattern, or 0 if none does.
+func tagLine(file, pattern string) int {
+	if pattern == "" {
+		return 0
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	anchored := strings.HasPrefix(pattern, "^")
+	pattern = strings.TrimPrefix(pattern, "^")
+	whole := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		text := sc.Text()
+		switch {
+		case anchored && whole && text == pattern,
+			anchored && !whole && strings.HasPrefix(text, pattern),
+			!anchored && whole && strings.HasSuffix(text, pattern),
+			!anchored && !whole && strings.Contains(text, pattern):
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// repoConfigName is the name of the configuration file of a repository, as
+// written by -there, and as looked for in the current directory and its
+// parents.
+const repoConfigName = "gofind.json"
+
+// userConfigPath returns the path of the user configuration file, whose
+// languages and projects are added to the ones of any configuration file.
+func userConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gofinder", "config.json")
+}
+
+// userConfigFor returns the path of the user configuration file, if it
+// exists, and if it is not file itself.
+func userConfigFor(file string) string {
+	user := userConfigPath()
+	if user == "" {
+		return ""
+	}
+	if _, err := os.Stat(user); err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(file)
+	if err == nil && abs == user {
+		return ""
+	}
+	return user
+}
+
+// findRepoConfig returns the first gofind.json found in dir, an absolute
+// path, or in its parents.
+func findRepoConfig(dir string) (string, bool) {
+	for {
+		file := filepath.Join(dir, repoConfigName)
+		if _, err := os.Stat(file); err == nil {
+			return file, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// defaultConfigFile returns the configuration file to use when none was
+// given: the first gofind.json found in the current directory or its
+// parents, or else the user configuration file.
+func defaultConfigFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if file, ok := findRepoConfig(dir); ok {
+		return file, nil
+	}
+	user := userConfigPath()
+	if _, err := os.Stat(user); err == nil {
+		return user, nil
+	}
+	return "", fmt.Errorf("no configuration file given, no %v in the current directory or its parents, and no %v", repoConfigName, user)
+}
+
+// watchConfigs sends on changed whenever the configuration file, or the
+// user configuration file, changes.
+func watchConfigs(changed chan<- bool) {
+	if user := userConfigPath(); user != "" {
+		if abs, err := filepath.Abs(configFile); err != nil || abs != user {
+			go watchConfig(user, changed)
+		}
+	}
+	watchConfig(configFile, changed)
+}
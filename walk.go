@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// walkFiles calls fn for each regular file below locations that has one of
+// the exts extensions, and that is not excluded by one of the excl patterns.
+// The regexps are matched against the whole path, as with find -regex, and
+// the globs against the path relative to its location. A
+// non nil error from fn stops the walk.
+func walkFiles(locations, exts, excl []string, fn func(path string) error) error {
+	filter, err := newPathFilter(exts, excl)
+	if err != nil {
+		return err
+	}
+	for _, loc := range locations {
+		err := filepath.Walk(loc, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				// Unreadable directories, or files that vanished in
+				// the meantime, are simply skipped, like find does.
+				return nil
+			}
+			if !fi.Mode().IsRegular() || !filter.selects(loc, path) {
+				return nil
+			}
+			return fn(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
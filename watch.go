@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// pollInterval is how often the configuration file is checked for
+	// changes, when it can't be watched.
+	pollInterval = 2 * time.Second
+	// settleDelay is how long we wait after a change before we load the
+	// file, since editors often write it in several steps.
+	settleDelay = 200 * time.Millisecond
+)
+
+// watchConfig sends on changed whenever file changes. It uses inotify where
+// available, and polls the file otherwise.
+func watchConfig(file string, changed chan<- bool) {
+	if err := inotifyWatch(file, changed); err != nil {
+		log.Printf("could not watch %v with inotify (%v), polling it instead", file, err)
+	}
+	pollWatch(file, changed)
+}
+
+// pollWatch checks file for changes every pollInterval, forever.
+func pollWatch(file string, changed chan<- bool) {
+	last, _ := os.Stat(file)
+	for {
+		time.Sleep(pollInterval)
+		fi, _ := os.Stat(file)
+		if !sameFileInfo(last, fi) {
+			time.Sleep(settleDelay)
+			notifyChange(changed)
+			fi, _ = os.Stat(file)
+		}
+		last = fi
+	}
+}
+
+func sameFileInfo(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// notifyChange sends on changed, unless a change is already pending.
+func notifyChange(changed chan<- bool) {
+	select {
+	case changed <- true:
+	default:
+	}
+}
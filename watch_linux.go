@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyWatch sends on changed whenever file changes, for as long as inotify
+// works. The directory is watched rather than the file itself, since editors
+// often replace the file with a new one.
+func inotifyWatch(file string, changed chan<- bool) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(abs)
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		return err
+	}
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ours := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			if off > n {
+				break
+			}
+			if strings.TrimRight(string(buf[start:off]), "\x00") == name {
+				ours = true
+			}
+		}
+		if ours {
+			time.Sleep(settleDelay)
+			notifyChange(changed)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func inotifyWatch(file string, changed chan<- bool) error {
+	return errors.New("inotify is only available on linux")
+}